@@ -10,6 +10,9 @@ Popular Mechanics 1936
 package main
 
 import (
+	"image/color"
+	"log"
+
 	"github.com/deadsy/sdfx/sdf"
 )
 
@@ -26,6 +29,28 @@ func main() {
 	sdf.RenderSTL(sdf.ScaleUniform3D(cylinderPattern(true, true), scale), 330, "cylinder_pattern.stl")
 	sdf.RenderSTL(sdf.ScaleUniform3D(ccFrontPattern(), scale), 300, "crankcase_front.stl")
 
+	// the patterns as a scene for previews
+	glb, err := sdf.NewGLTF("midget.glb", "mm")
+	if err != nil {
+		log.Fatalf("error: %s", err)
+	}
+	cylinder := sdf.ScaleUniform3D(cylinderPattern(true, true), scale)
+	crankcase := sdf.ScaleUniform3D(ccFrontPattern(), scale)
+	m := sdf.RenderMesh3(cylinder, 200)
+	err = glb.AddObject("cylinder", m, m.VertexNormals(cylinder), sdf.Identity3d(), color.RGBA{0xb0, 0x50, 0x30, 0xff})
+	if err != nil {
+		log.Fatalf("error: %s", err)
+	}
+	m = sdf.RenderMesh3(crankcase, 200)
+	x := cylinder.BoundingBox().Size().X + crankcase.BoundingBox().Size().X
+	err = glb.AddObject("crankcase", m, m.VertexNormals(crankcase), sdf.Translate3d(sdf.V3{x * 0.6, 0, 0}), color.RGBA{0x50, 0x70, 0xa0, 0xff})
+	if err != nil {
+		log.Fatalf("error: %s", err)
+	}
+	if err := glb.Save(); err != nil {
+		log.Fatalf("error: %s", err)
+	}
+
 	//sdf.RenderSTL(sdf.ScaleUniform3D(cylinderCoreBox(), shrink), 330, "cylinder_corebox.stl")
 }
 
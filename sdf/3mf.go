@@ -0,0 +1,251 @@
+//-----------------------------------------------------------------------------
+/*
+
+3MF Files
+
+A 3MF file is a zip archive with an XML model of the meshes. Unlike STL it
+has units and can hold multiple named objects, each with a transform onto
+the build plate and an optional display color.
+
+See: https://3mf.io/specification/
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"sort"
+	"strconv"
+)
+
+//-----------------------------------------------------------------------------
+
+const threeMFContentTypes = `<?xml version="1.0" encoding="UTF-8"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+ <Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+ <Default Extension="model" ContentType="application/vnd.ms-package.3dmanufacturing-3dmodel+xml"/>
+</Types>
+`
+
+const threeMFRels = `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+ <Relationship Target="/3D/3dmodel.model" Id="rel0" Type="http://schemas.microsoft.com/3dmanufacturing/2013/01/3dmodel"/>
+</Relationships>
+`
+
+// threeMFUnits maps unit names to 3MF units.
+var threeMFUnits = map[string]string{
+	"":           "millimeter",
+	"mm":         "millimeter",
+	"millimeter": "millimeter",
+	"micron":     "micron",
+	"cm":         "centimeter",
+	"centimeter": "centimeter",
+	"inch":       "inch",
+	"foot":       "foot",
+	"m":          "meter",
+	"meter":      "meter",
+}
+
+//-----------------------------------------------------------------------------
+
+// threeMFObject is an object in a 3MF model.
+type threeMFObject struct {
+	name      string
+	mesh      *Mesh3
+	transform M44
+	color     color.Color
+}
+
+// ThreeMF is a 3MF model object.
+type ThreeMF struct {
+	name     string
+	unit     string
+	metadata map[string]string
+	objects  []*threeMFObject
+}
+
+// NewThreeMF returns an empty 3MF model object.
+// The unit is one of "mm", "inch", "micron", "cm", "m" or "foot".
+func NewThreeMF(name, unit string) (*ThreeMF, error) {
+	u, ok := threeMFUnits[unit]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit \"%s\"", unit)
+	}
+	return &ThreeMF{
+		name:     name,
+		unit:     u,
+		metadata: map[string]string{"Application": "sdfx"},
+	}, nil
+}
+
+// Metadata sets a metadata value (e.g. "Title", "Designer", "Copyright").
+func (d *ThreeMF) Metadata(name, value string) {
+	d.metadata[name] = value
+}
+
+// AddObject adds a named mesh to a 3MF model object.
+// The transform places the mesh on the build plate (Identity3d() == none).
+// The color is optional (nil == no color).
+func (d *ThreeMF) AddObject(name string, mesh *Mesh3, transform M44, c color.Color) error {
+	if mesh == nil || len(mesh.F) == 0 {
+		return errors.New("empty mesh")
+	}
+	d.objects = append(d.objects, &threeMFObject{
+		name:      name,
+		mesh:      mesh,
+		transform: transform,
+		color:     c,
+	})
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// threeMFFloat formats a number for a 3MF model.
+func threeMFFloat(x float64) string {
+	return strconv.FormatFloat(x, 'g', 9, 64)
+}
+
+// threeMFTransform formats a transform for a 3MF model.
+// 3MF uses row vectors, so the matrix is transposed.
+func threeMFTransform(m M44) string {
+	v := []float64{
+		m.x00, m.x10, m.x20,
+		m.x01, m.x11, m.x21,
+		m.x02, m.x12, m.x22,
+		m.x03, m.x13, m.x23,
+	}
+	s := threeMFFloat(v[0])
+	for _, x := range v[1:] {
+		s += " " + threeMFFloat(x)
+	}
+	return s
+}
+
+// threeMFColor formats a color for a 3MF model.
+func threeMFColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02X%02X%02X%02X", n.R, n.G, n.B, n.A)
+}
+
+// threeMFEscape returns an XML escaped string.
+func threeMFEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// encodeModel writes the 3D model XML.
+func (d *ThreeMF) encodeModel(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(buf, "<model unit=\"%s\" xml:lang=\"en-US\" xmlns=\"http://schemas.microsoft.com/3dmanufacturing/core/2015/02\">\n", d.unit)
+
+	// metadata, sorted for repeatable output
+	var names []string
+	for k := range d.metadata {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(buf, " <metadata name=\"%s\">%s</metadata>\n", threeMFEscape(k), threeMFEscape(d.metadata[k]))
+	}
+
+	fmt.Fprintf(buf, " <resources>\n")
+	// the object colors are a base material group (id 1)
+	id := 1
+	colors := 0
+	for _, o := range d.objects {
+		if o.color != nil {
+			if colors == 0 {
+				fmt.Fprintf(buf, "  <basematerials id=\"%d\">\n", id)
+			}
+			fmt.Fprintf(buf, "   <base name=\"%s\" displaycolor=\"%s\"/>\n", threeMFEscape(o.name), threeMFColor(o.color))
+			colors++
+		}
+	}
+	if colors > 0 {
+		fmt.Fprintf(buf, "  </basematerials>\n")
+		id++
+	}
+	// objects
+	pindex := 0
+	for i, o := range d.objects {
+		fmt.Fprintf(buf, "  <object id=\"%d\" name=\"%s\" type=\"model\"", id+i, threeMFEscape(o.name))
+		if o.color != nil {
+			fmt.Fprintf(buf, " pid=\"1\" pindex=\"%d\"", pindex)
+			pindex++
+		}
+		fmt.Fprintf(buf, ">\n   <mesh>\n    <vertices>\n")
+		for _, v := range o.mesh.V {
+			fmt.Fprintf(buf, "     <vertex x=\"%s\" y=\"%s\" z=\"%s\"/>\n", threeMFFloat(v.X), threeMFFloat(v.Y), threeMFFloat(v.Z))
+		}
+		fmt.Fprintf(buf, "    </vertices>\n    <triangles>\n")
+		for _, f := range o.mesh.F {
+			fmt.Fprintf(buf, "     <triangle v1=\"%d\" v2=\"%d\" v3=\"%d\"/>\n", f[0], f[1], f[2])
+		}
+		fmt.Fprintf(buf, "    </triangles>\n   </mesh>\n  </object>\n")
+	}
+	fmt.Fprintf(buf, " </resources>\n")
+
+	// build items
+	fmt.Fprintf(buf, " <build>\n")
+	for i, o := range d.objects {
+		fmt.Fprintf(buf, "  <item objectid=\"%d\"", id+i)
+		if o.transform != Identity3d() {
+			fmt.Fprintf(buf, " transform=\"%s\"", threeMFTransform(o.transform))
+		}
+		fmt.Fprintf(buf, "/>\n")
+	}
+	fmt.Fprintf(buf, " </build>\n</model>\n")
+	return buf.Flush()
+}
+
+// Encode writes a 3MF model object to a writer.
+func (d *ThreeMF) Encode(w io.Writer) error {
+	if len(d.objects) == 0 {
+		return errors.New("no objects in 3MF model")
+	}
+	z := zip.NewWriter(w)
+	parts := []struct {
+		name  string
+		write func(w io.Writer) error
+	}{
+		{"[Content_Types].xml", func(w io.Writer) error {
+			_, err := io.WriteString(w, threeMFContentTypes)
+			return err
+		}},
+		{"_rels/.rels", func(w io.Writer) error {
+			_, err := io.WriteString(w, threeMFRels)
+			return err
+		}},
+		{"3D/3dmodel.model", d.encodeModel},
+	}
+	for _, p := range parts {
+		f, err := z.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if err := p.write(f); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// Save writes a 3MF model object to a file.
+func (d *ThreeMF) Save() error {
+	return saveFile(d.name, d.Encode)
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,428 @@
+//-----------------------------------------------------------------------------
+/*
+
+Contours
+
+Marching squares generates unordered line segments. These are chained into
+contours by matching the end points of the segments. The contours are
+oriented and nested, outer boundaries are counter-clockwise and holes are
+clockwise, so they can be written as polylines or turned into polygons.
+
+A set of closed contours can be turned back into an SDF2 with an exact
+euclidean distance. This is useful for slices of an SDF3, where the distance
+near the top and bottom of the object is the distance to the top and bottom
+surfaces, not to the boundary of the slice.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+//-----------------------------------------------------------------------------
+
+// contourKey is a quantized point used to match segment end points.
+type contourKey struct {
+	x, y int64
+}
+
+// newContourKey returns the key for a point.
+func newContourKey(p V2, tolerance float64) contourKey {
+	return contourKey{
+		int64(math.Floor(p.X/tolerance + 0.5)),
+		int64(math.Floor(p.Y/tolerance + 0.5)),
+	}
+}
+
+// chainLines chains line segments into contours.
+// The segment directions are not used, so the contours may have either
+// orientation. Closed contours don't repeat the first point. Open contours
+// (e.g. at the edge of a bounding box) are returned as they are found.
+func chainLines(lines []*Line, tolerance float64) (closed, open []V2Set) {
+	// index the segments by their end points
+	index := make(map[contourKey][]int, 2*len(lines))
+	for i, l := range lines {
+		for _, p := range l {
+			k := newContourKey(p, tolerance)
+			index[k] = append(index[k], i)
+		}
+	}
+	used := make([]bool, len(lines))
+	// next returns the other end of an unused segment at a point.
+	next := func(p V2) (V2, bool) {
+		k := newContourKey(p, tolerance)
+		for _, i := range index[k] {
+			if used[i] {
+				continue
+			}
+			used[i] = true
+			if newContourKey(lines[i][0], tolerance) == k {
+				return lines[i][1], true
+			}
+			return lines[i][0], true
+		}
+		return V2{}, false
+	}
+	// follow returns the points of a chain from a point.
+	follow := func(p V2) V2Set {
+		var c V2Set
+		for {
+			q, ok := next(p)
+			if !ok {
+				return c
+			}
+			c = append(c, q)
+			p = q
+		}
+	}
+	for i := range lines {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		fwd := append(V2Set{lines[i][0], lines[i][1]}, follow(lines[i][1])...)
+		last := fwd[len(fwd)-1]
+		if newContourKey(last, tolerance) == newContourKey(fwd[0], tolerance) {
+			closed = append(closed, fwd[:len(fwd)-1])
+			continue
+		}
+		// open, so follow the chain backwards from the first point
+		bwd := follow(fwd[0])
+		c := make(V2Set, 0, len(bwd)+len(fwd))
+		for j := len(bwd) - 1; j >= 0; j-- {
+			c = append(c, bwd[j])
+		}
+		open = append(open, append(c, fwd...))
+	}
+	return closed, open
+}
+
+//-----------------------------------------------------------------------------
+
+// Contour is a closed contour of an SDF2.
+type Contour struct {
+	Points   V2Set      // contour points, the first point is not repeated
+	Hole     bool       // is this contour a hole?
+	Parent   *Contour   // enclosing contour (nil == top level)
+	Children []*Contour // enclosed contours
+}
+
+// contourArea returns the signed area of a closed contour (> 0 == counter-clockwise).
+func contourArea(c V2Set) float64 {
+	var a float64
+	for i := range c {
+		p0, p1 := c[i], c[(i+1)%len(c)]
+		a += p0.X*p1.Y - p1.X*p0.Y
+	}
+	return 0.5 * a
+}
+
+// contourInside returns true if a point is inside a closed contour.
+func contourInside(c V2Set, p V2) bool {
+	in := false
+	for i := range c {
+		a, b := c[i], c[(i+1)%len(c)]
+		if (a.Y > p.Y) != (b.Y > p.Y) {
+			if x := a.X + (p.Y-a.Y)*(b.X-a.X)/(b.Y-a.Y); x > p.X {
+				in = !in
+			}
+		}
+	}
+	return in
+}
+
+// Area returns the signed area of a contour.
+// Outer contours have positive area, holes have negative area.
+func (c *Contour) Area() float64 {
+	return contourArea(c.Points)
+}
+
+// Polygon returns a contour as a closed polygon.
+func (c *Contour) Polygon() *Polygon {
+	p := NewPolygon()
+	p.AddV2Set(c.Points)
+	p.Close()
+	return p
+}
+
+// Contours chains line segments (e.g. from RenderLines2) into closed contours.
+// End points closer than the tolerance are joined. The top level contours are
+// returned, the enclosed contours are their children. Open chains are dropped.
+func Contours(lines []*Line, tolerance float64) []*Contour {
+	closed, _ := chainLines(lines, tolerance)
+	return nestContours(closed, false)
+}
+
+// nestContours returns the tree of closed contours that don't cross each other.
+// The inside is filled with the even-odd or nonzero (winding number) rule,
+// contours that don't change the fill (nonzero only) are dropped.
+func nestContours(closed []V2Set, nonzero bool) []*Contour {
+	// sort by area, so enclosing contours come before the contours they enclose
+	contours := make([]*Contour, 0, len(closed))
+	area := make(map[*Contour]float64, len(closed))
+	for _, c := range closed {
+		if len(c) < 3 {
+			continue
+		}
+		cc := &Contour{Points: c}
+		contours = append(contours, cc)
+		area[cc] = contourArea(c)
+	}
+	sort.SliceStable(contours, func(i, j int) bool {
+		return Abs(area[contours[i]]) > Abs(area[contours[j]])
+	})
+	// the winding number inside each contour
+	winding := make(map[*Contour]int, len(contours))
+	filled := func(c *Contour) bool {
+		if c == nil {
+			return false
+		}
+		if nonzero {
+			return winding[c] != 0
+		}
+		return winding[c]&1 != 0
+	}
+	// the closest enclosing contour that is kept
+	kept := make(map[*Contour]*Contour, len(contours))
+	var roots []*Contour
+	for i, c := range contours {
+		// the parent is the smallest enclosing contour
+		var parent *Contour
+		for j := i - 1; j >= 0; j-- {
+			if contourInside(contours[j].Points, c.Points[0]) {
+				parent = contours[j]
+				break
+			}
+		}
+		winding[c] = winding[parent] + 1
+		if nonzero && area[c] < 0 {
+			winding[c] = winding[parent] - 1
+		}
+		if filled(c) == filled(parent) {
+			kept[c] = kept[parent]
+			continue
+		}
+		kept[c] = c
+		c.Parent = kept[parent]
+		c.Hole = !filled(c)
+		if c.Parent == nil {
+			roots = append(roots, c)
+		} else {
+			c.Parent.Children = append(c.Parent.Children, c)
+		}
+		// outer contours are counter-clockwise, holes are clockwise
+		if (area[c] < 0) != c.Hole {
+			for a, b := 0, len(c.Points)-1; a < b; a, b = a+1, b-1 {
+				c.Points[a], c.Points[b] = c.Points[b], c.Points[a]
+			}
+		}
+	}
+	return roots
+}
+
+// FlattenContours returns a contour tree as a list, parents before children.
+func FlattenContours(contours []*Contour) []*Contour {
+	var list []*Contour
+	for _, c := range contours {
+		list = append(list, c)
+		list = append(list, FlattenContours(c.Children)...)
+	}
+	return list
+}
+
+// Contour2D returns an SDF2 for a contour tree.
+func Contour2D(contours []*Contour) (SDF2, error) {
+	var c []V2Set
+	for _, x := range FlattenContours(contours) {
+		c = append(c, x.Points)
+	}
+	return newContourSDF2(c)
+}
+
+// ContourPolygon2D returns an SDF2 for a contour tree built from polygons.
+// The holes of each outer contour are removed with Difference2D.
+func ContourPolygon2D(contours []*Contour) SDF2 {
+	var parts []SDF2
+	for _, c := range FlattenContours(contours) {
+		if c.Hole {
+			continue
+		}
+		var holes []SDF2
+		for _, h := range c.Children {
+			holes = append(holes, Polygon2D(append(V2Set(nil), h.Points...)))
+		}
+		parts = append(parts, Difference2D(Polygon2D(append(V2Set(nil), c.Points...)), Union2D(holes...)))
+	}
+	return Union2D(parts...)
+}
+
+//-----------------------------------------------------------------------------
+
+// simplifyContour removes contour points that deviate from a straight line
+// between their neighbours by less than the tolerance.
+func simplifyContour(c V2Set, tolerance float64, closed bool) V2Set {
+	if len(c) < 4 {
+		return c
+	}
+	out := V2Set{c[0]}
+	for i := 1; i < len(c); i++ {
+		var b V2
+		if i+1 < len(c) {
+			b = c[i+1]
+		} else if closed {
+			b = out[0]
+		} else {
+			out = append(out, c[i])
+			break
+		}
+		a := out[len(out)-1]
+		if Abs(newLinePP(a, b).Distance(c[i])) >= tolerance || a.Equals(b, 0) {
+			out = append(out, c[i])
+		}
+	}
+	return out
+}
+
+//-----------------------------------------------------------------------------
+
+// ContourSDF2 is an SDF2 for a set of closed contours.
+// The inside is determined with the even-odd rule, so holes are contours
+// within contours. The segments are kept in a uniform grid of cells.
+type ContourSDF2 struct {
+	lines []Line
+	cells [][]int // segments overlapping each cell
+	rows  [][]int // segments overlapping each row of cells
+	nx    int     // number of cells in x
+	ny    int     // number of cells in y
+	size  float64 // cell size
+	grid  Box2    // grid area
+	bb    Box2
+}
+
+// newContourSDF2 returns an SDF2 for a set of closed contours.
+func newContourSDF2(contours []V2Set) (SDF2, error) {
+	s := ContourSDF2{}
+	for _, c := range contours {
+		for i := range c {
+			l := Line{c[i], c[(i+1)%len(c)]}
+			if !l.Degenerate(0) {
+				s.lines = append(s.lines, l)
+			}
+		}
+	}
+	if len(s.lines) == 0 {
+		return nil, errors.New("no contours")
+	}
+	var points V2Set
+	for _, l := range s.lines {
+		points = append(points, l[0], l[1])
+	}
+	s.bb = Box2{points.Min(), points.Max()}
+	// about 2 segments per cell
+	size := s.bb.Size()
+	s.size = math.Max(math.Sqrt(2*size.X*size.Y/float64(len(s.lines))), 1e-3*size.MaxComponent())
+	s.nx = int(size.X/s.size) + 1
+	s.ny = int(size.Y/s.size) + 1
+	s.grid = Box2{s.bb.Min, s.bb.Min.Add(V2{float64(s.nx), float64(s.ny)}.MulScalar(s.size))}
+	s.cells = make([][]int, s.nx*s.ny)
+	s.rows = make([][]int, s.ny)
+	for i, l := range s.lines {
+		x0, y0 := s.cell(l[0].Min(l[1]))
+		x1, y1 := s.cell(l[0].Max(l[1]))
+		for y := y0; y <= y1; y++ {
+			s.rows[y] = append(s.rows[y], i)
+			for x := x0; x <= x1; x++ {
+				s.cells[y*s.nx+x] = append(s.cells[y*s.nx+x], i)
+			}
+		}
+	}
+	return &s, nil
+}
+
+// cell returns the grid cell for a point, clamped to the grid.
+func (s *ContourSDF2) cell(p V2) (int, int) {
+	v := p.Sub(s.grid.Min).DivScalar(s.size)
+	x := int(Clamp(math.Floor(v.X), 0, float64(s.nx-1)))
+	y := int(Clamp(math.Floor(v.Y), 0, float64(s.ny-1)))
+	return x, y
+}
+
+// distance2 returns the distance squared to the closest segment.
+func (s *ContourSDF2) distance2(p V2) float64 {
+	cx, cy := s.cell(p)
+	best := math.Inf(1)
+	n := s.nx
+	if s.ny > n {
+		n = s.ny
+	}
+	for r := 0; r <= n; r++ {
+		// visit the cells of ring r about the cell
+		for y := cy - r; y <= cy+r; y++ {
+			if y < 0 || y >= s.ny {
+				continue
+			}
+			step := 1
+			if y != cy-r && y != cy+r {
+				step = 2 * r
+			}
+			for x := cx - r; x <= cx+r; x += step {
+				if x >= 0 && x < s.nx {
+					for _, i := range s.cells[y*s.nx+x] {
+						best = math.Min(best, lineDistance2(s.lines[i], p))
+					}
+				}
+			}
+		}
+		// the cells of the next ring are at least r cells away
+		if d := float64(r) * s.size; best <= d*d {
+			break
+		}
+	}
+	return best
+}
+
+// inside returns true if a point is inside the contours (even-odd rule).
+func (s *ContourSDF2) inside(p V2) bool {
+	if p.Y < s.bb.Min.Y || p.Y > s.bb.Max.Y {
+		return false
+	}
+	_, y := s.cell(p)
+	in := false
+	for _, i := range s.rows[y] {
+		a, b := s.lines[i][0], s.lines[i][1]
+		if (a.Y > p.Y) != (b.Y > p.Y) {
+			if x := a.X + (p.Y-a.Y)*(b.X-a.X)/(b.Y-a.Y); x > p.X {
+				in = !in
+			}
+		}
+	}
+	return in
+}
+
+// Evaluate returns the minimum distance to the contours.
+func (s *ContourSDF2) Evaluate(p V2) float64 {
+	d := math.Sqrt(s.distance2(p))
+	if s.inside(p) {
+		return -d
+	}
+	return d
+}
+
+// BoundingBox returns the bounding box of the contours.
+func (s *ContourSDF2) BoundingBox() Box2 {
+	return s.bb
+}
+
+// lineDistance2 returns the distance squared from a point to a line segment.
+func lineDistance2(l Line, p V2) float64 {
+	v := l[1].Sub(l[0])
+	t := Clamp(p.Sub(l[0]).Dot(v)/v.Dot(v), 0, 1)
+	return l[0].Add(v.MulScalar(t)).Sub(p).Length2()
+}
+
+//-----------------------------------------------------------------------------
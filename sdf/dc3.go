@@ -0,0 +1,420 @@
+//-----------------------------------------------------------------------------
+/*
+
+Dual Contouring
+
+Convert an SDF3 to a triangle mesh.
+Uses octree space subdivision to find the surface cells.
+
+Marching cubes places vertices on the cell edges, so sharp features of the
+SDF3 get rounded off. Dual contouring places one vertex within each surface
+cell by minimising a quadratic error function (QEF) built from the surface
+intersection points and normals. Cells that contain an edge or corner of the
+object get a vertex on that feature, so boxes, hex heads and flanges keep
+their crisp edges.
+
+The octree can be adaptive. Cubes where the SDF3 is close to linear are not
+subdivided further, so flat regions get large triangles while curved and
+detailed regions keep the full resolution. The polygons are generated for
+the minimal edges of the octree, which joins cells of different sizes
+without cracks.
+
+See: "Dual Contouring of Hermite Data", Ju, Losasso, Schaefer, Warren
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+// dcTruncate is the relative size below which QEF eigenvalues are ignored.
+// This stops flat and edge-like cells from placing vertices far away from the
+// mass point of the surface intersections.
+const dcTruncate = 0.1
+
+// dcIterations is the maximum number of root finding iterations for an edge intersection.
+const dcIterations = 16
+
+// dcEdge identifies a cell edge by its lower corner, axis and length.
+type dcEdge struct {
+	v    V3i  // lower corner of the edge
+	axis int  // 0,1,2 == x,y,z
+	n    uint // level of the edge, length = 1 << n
+}
+
+// dcAxis returns the unit vector for an axis.
+func dcAxis(axis int) V3i {
+	var v V3i
+	v[axis] = 1
+	return v
+}
+
+// dcLeaf is a leaf cube of the octree with the QEF for its vertex.
+type dcLeaf struct {
+	c     cube          // the leaf cube
+	empty bool          // the leaf cube contains no surface
+	ata   [3][3]float64 // QEF normal matrix
+	atb   V3            // QEF right hand side
+	mass  V3            // sum of the surface intersection points
+	count int           // number of surface intersections
+	index int           // mesh vertex index
+}
+
+// add adds a surface intersection point and normal to the QEF of the leaf.
+func (l *dcLeaf) add(p, n V3) {
+	nf := [3]float64{n.X, n.Y, n.Z}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			l.ata[i][j] += nf[i] * nf[j]
+		}
+	}
+	l.atb = l.atb.Add(n.MulScalar(n.Dot(p)))
+	l.mass = l.mass.Add(p)
+	l.count++
+}
+
+//-----------------------------------------------------------------------------
+
+// dualContour stores the state for a dual contouring render.
+type dualContour struct {
+	dc        *dcache3         // distance cache
+	levels    uint             // number of octree levels
+	tolerance float64          // planarity tolerance for adaptive subdivision (0 == uniform)
+	leaves    map[cube]*dcLeaf // leaf cubes of the octree
+	order     []*dcLeaf        // leaf cubes in creation order
+	edges     map[dcEdge]bool  // edges that have been processed
+	polygons  [][]*dcLeaf      // output polygons (leaf vertices)
+	step      float64          // step size for gradient estimation
+	r         *renderState     // render state (may be nil)
+}
+
+// normal returns the normal of the SDF3 surface at p.
+func (d *dualContour) normal(p V3) V3 {
+	return Normal3(d.dc.s, p, &GradientParms{Step: d.step})
+}
+
+// intersect returns the surface intersection point on a cell edge.
+// Dual contouring needs accurate intersections (linear interpolation is only
+// exact for planar surfaces) so the point is refined with the SDF.
+func (d *dualContour) intersect(p0, p1 V3, d0, d1 float64) V3 {
+	return mcRefine(d.dc.s, p0, p1, d0, d1, 0, dcIterations)
+}
+
+// addLeaf adds a leaf cube to the octree.
+func (d *dualContour) addLeaf(c cube) *dcLeaf {
+	l := &dcLeaf{c: c}
+	d.leaves[c] = l
+	d.order = append(d.order, l)
+	return l
+}
+
+// leafAt returns the leaf cube containing the lattice point q.
+// q should be within a cube, not on a boundary.
+func (d *dualContour) leafAt(q V3i) *dcLeaf {
+	for n := uint(1); n < d.levels; n++ {
+		mask := ^((1 << n) - 1)
+		c := cube{V3i{q[0] & mask, q[1] & mask, q[2] & mask}, n}
+		if l, found := d.leaves[c]; found {
+			return l
+		}
+	}
+	return nil
+}
+
+// isPlanar returns true if the SDF3 is linear (to within the tolerance) over the cube.
+// The surface within a planar cube can be represented with a single vertex.
+func (d *dualContour) isPlanar(c *cube) bool {
+	if d.tolerance <= 0 {
+		return false
+	}
+	// sample a 3x3x3 grid over the cube
+	h := 1 << (c.n - 1) // half side
+	center := c.v.AddScalar(h)
+	var dist [27]float64
+	var ofs [27]V3
+	var mean float64
+	i := 0
+	for x := -1; x <= 1; x++ {
+		for y := -1; y <= 1; y++ {
+			for z := -1; z <= 1; z++ {
+				o := V3i{x, y, z}
+				_, dist[i] = d.dc.evaluate(center.Add(o.MulScalar(h)))
+				ofs[i] = o.ToV3().MulScalar(float64(h) * d.dc.resolution)
+				mean += dist[i]
+				i++
+			}
+		}
+	}
+	mean /= 27
+	// least squares fit of a linear function over the grid
+	var g V3
+	for i := range dist {
+		g = g.Add(ofs[i].MulScalar(dist[i]))
+	}
+	k := float64(h) * d.dc.resolution
+	g = g.DivScalar(18 * k * k)
+	// check the residuals
+	for i := range dist {
+		if Abs(dist[i]-(mean+g.Dot(ofs[i]))) > d.tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// processEdge adds the polygon for a minimal cell edge with a sign change.
+func (d *dualContour) processEdge(e dcEdge) {
+	if d.edges[e] {
+		return
+	}
+	d.edges[e] = true
+	side := 1 << e.n
+	ek := dcAxis(e.axis).MulScalar(side)
+	p0, d0 := d.dc.evaluate(e.v)
+	p1, d1 := d.dc.evaluate(e.v.Add(ek))
+	if (d0 < 0) == (d1 < 0) {
+		return
+	}
+	// The 4 cells sharing the edge, counter-clockwise about the edge axis.
+	q := e.v.Add(dcAxis(e.axis).MulScalar(side >> 1))
+	eu := dcAxis((e.axis + 1) % 3)
+	ev := dcAxis((e.axis + 2) % 3)
+	qs := [4]V3i{
+		q.Add(eu).Add(ev),
+		q.Sub(eu).Add(ev),
+		q.Sub(eu).Sub(ev),
+		q.Add(eu).Sub(ev),
+	}
+	var cells [4]*dcLeaf
+	for i, qi := range qs {
+		l := d.leafAt(qi)
+		if l == nil {
+			// Not part of the octree (the SDF3 isn't a distance bound, or the
+			// surface is outside the bounding box), so add a cell of the same
+			// size as the edge.
+			mask := ^(side - 1)
+			l = d.addLeaf(cube{V3i{qi[0] & mask, qi[1] & mask, qi[2] & mask}, e.n})
+		}
+		if l.c.n < e.n {
+			// This edge is subdivided by a smaller cell, so it isn't minimal.
+			// The smaller edges will be processed instead.
+			return
+		}
+		cells[i] = l
+	}
+	// add the intersection to the QEF for each cell
+	p := d.intersect(p0, p1, d0, d1)
+	n := d.normal(p)
+	var polygon []*dcLeaf
+	for i, l := range cells {
+		if l != cells[(i+3)%4] {
+			l.add(p, n)
+			polygon = append(polygon, l)
+		}
+	}
+	if len(polygon) < 3 {
+		return
+	}
+	// The polygon normal is along +axis, reverse it if the outside is -axis.
+	if d0 >= 0 {
+		for i, j := 0, len(polygon)-1; i < j; i, j = i+1, j-1 {
+			polygon[i], polygon[j] = polygon[j], polygon[i]
+		}
+	}
+	d.polygons = append(d.polygons, polygon)
+}
+
+// processLeaf processes the edges of a leaf cube.
+func (d *dualContour) processLeaf(c cube) {
+	side := 1 << c.n
+	for axis := 0; axis < 3; axis++ {
+		eu := dcAxis((axis + 1) % 3).MulScalar(side)
+		ev := dcAxis((axis + 2) % 3).MulScalar(side)
+		for _, uv := range [4]V2i{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+			a := c.v.Add(eu.MulScalar(uv[0])).Add(ev.MulScalar(uv[1]))
+			d.processEdge(dcEdge{a, axis, c.n})
+		}
+	}
+}
+
+// processCube processes a cube. Generate leaf cubes, or more cubes.
+// Empty cubes are also leaves so the leaf cubes partition the bounding box.
+// Building the octree is the first half of the render progress.
+func (d *dualContour) processCube(c *cube) {
+	if d.r.cancelled() {
+		return
+	}
+	if d.dc.isEmpty(c) {
+		d.addLeaf(*c).empty = true
+		d.r.advance(0.5 * d.dc.fraction(c))
+		return
+	}
+	if c.n == 1 || d.isPlanar(c) {
+		// this cube is at the required resolution
+		d.addLeaf(*c)
+		d.r.advance(0.5 * d.dc.fraction(c))
+		return
+	}
+	// process the sub cubes
+	n := c.n - 1
+	s := 1 << n
+	d.processCube(&cube{c.v.Add(V3i{0, 0, 0}), n})
+	d.processCube(&cube{c.v.Add(V3i{s, 0, 0}), n})
+	d.processCube(&cube{c.v.Add(V3i{s, s, 0}), n})
+	d.processCube(&cube{c.v.Add(V3i{0, s, 0}), n})
+	d.processCube(&cube{c.v.Add(V3i{0, 0, s}), n})
+	d.processCube(&cube{c.v.Add(V3i{s, 0, s}), n})
+	d.processCube(&cube{c.v.Add(V3i{s, s, s}), n})
+	d.processCube(&cube{c.v.Add(V3i{0, s, s}), n})
+}
+
+// solveLeaf returns the QEF minimising vertex position for a leaf cube.
+func (d *dualContour) solveLeaf(l *dcLeaf) V3 {
+	// cell bounds
+	cMin, _ := d.dc.evaluate(l.c.v)
+	cMax := cMin.AddScalar(float64(int(1)<<l.c.n) * d.dc.resolution)
+	mass := l.mass.DivScalar(float64(l.count))
+	// solve relative to the mass point: ata.(x - m) = atb - ata.m
+	r := l.atb.Sub(dcMulM3(l.ata, mass))
+	v := mass.Add(dcSolve(l.ata, r))
+	// keep the vertex within the cell
+	return v.Clamp(cMin, cMax)
+}
+
+//-----------------------------------------------------------------------------
+
+// dualContouringOctree generates a triangle mesh for an SDF3 using dual contouring.
+// With tolerance > 0 the octree is adaptive: cubes where the SDF3 is linear to
+// within the tolerance are not subdivided, so flat regions get large triangles.
+// The polygons are generated for the minimal edges of the octree, so cells of
+// different sizes are joined without cracks. The render state (if not nil)
+// controls cancellation and progress.
+func dualContouringOctree(s SDF3, resolution, tolerance float64, r *renderState) *Mesh3 {
+	// Pad the bounding box by a cell to make sure the surface doesn't cross
+	// the edges of the boundary cells.
+	bb := s.BoundingBox()
+	bb = Box3{bb.Min.SubScalar(resolution), bb.Max.AddScalar(resolution)}
+	longAxis := bb.Size().MaxComponent()
+	// We want to test the smallest cube (side == resolution) for emptiness
+	// so the level = 0 cube is at half resolution.
+	resolution = 0.5 * resolution
+	// how many cube levels for the octree?
+	levels := uint(math.Ceil(math.Log2(longAxis/resolution))) + 1
+	d := dualContour{
+		dc:        newDcache3(s, bb.Min, resolution, levels, 0),
+		levels:    levels,
+		tolerance: tolerance,
+		leaves:    make(map[cube]*dcLeaf),
+		edges:     make(map[dcEdge]bool),
+		step:      1e-3 * resolution,
+		r:         r,
+	}
+	// build the octree, start at the top level
+	d.processCube(&cube{V3i{0, 0, 0}, levels - 1})
+	// generate the polygons for the leaf cube edges
+	n := len(d.order)
+	for i := 0; i < n; i++ {
+		if r.cancelled() {
+			break
+		}
+		if !d.order[i].empty {
+			d.processLeaf(d.order[i].c)
+		}
+		r.advance(0.5 / float64(n))
+	}
+	// solve for the leaf vertices
+	m := &Mesh3{}
+	for _, l := range d.order {
+		if l.count > 0 {
+			l.index = m.AddVertex(d.solveLeaf(l))
+		}
+	}
+	// output the triangles
+	for _, p := range d.polygons {
+		for i := 2; i < len(p); i++ {
+			m.AddFace(p[0].index, p[i-1].index, p[i].index)
+		}
+	}
+	r.addCache(d.dc.lookups, d.dc.hits)
+	return m
+}
+
+//-----------------------------------------------------------------------------
+// QEF solving
+
+// dcMulM3 returns the product of a 3x3 matrix and a vector.
+func dcMulM3(a [3][3]float64, v V3) V3 {
+	return V3{
+		a[0][0]*v.X + a[0][1]*v.Y + a[0][2]*v.Z,
+		a[1][0]*v.X + a[1][1]*v.Y + a[1][2]*v.Z,
+		a[2][0]*v.X + a[2][1]*v.Y + a[2][2]*v.Z,
+	}
+}
+
+// dcEigen returns the eigenvalues and eigenvectors (columns of v) of a
+// symmetric 3x3 matrix using Jacobi rotations.
+func dcEigen(a [3][3]float64) ([3]float64, [3][3]float64) {
+	v := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	for sweep := 0; sweep < 32; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off < epsilon {
+			break
+		}
+		for _, pq := range [3][2]int{{0, 1}, {0, 2}, {1, 2}} {
+			p, q := pq[0], pq[1]
+			if a[p][q] == 0 {
+				continue
+			}
+			theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+			t := 1 / (Abs(theta) + math.Sqrt(theta*theta+1))
+			if theta < 0 {
+				t = -t
+			}
+			c := 1 / math.Sqrt(t*t+1)
+			s := t * c
+			for k := 0; k < 3; k++ {
+				akp, akq := a[k][p], a[k][q]
+				a[k][p] = c*akp - s*akq
+				a[k][q] = s*akp + c*akq
+			}
+			for k := 0; k < 3; k++ {
+				apk, aqk := a[p][k], a[q][k]
+				a[p][k] = c*apk - s*aqk
+				a[q][k] = s*apk + c*aqk
+			}
+			for k := 0; k < 3; k++ {
+				vkp, vkq := v[k][p], v[k][q]
+				v[k][p] = c*vkp - s*vkq
+				v[k][q] = s*vkp + c*vkq
+			}
+		}
+	}
+	return [3]float64{a[0][0], a[1][1], a[2][2]}, v
+}
+
+// dcSolve returns the least squares solution x of ata.x = atb.
+// It uses a truncated pseudo-inverse so degenerate systems give the minimum norm solution.
+func dcSolve(ata [3][3]float64, atb V3) V3 {
+	w, v := dcEigen(ata)
+	wMax := Max(Abs(w[0]), Max(Abs(w[1]), Abs(w[2])))
+	b := [3]float64{atb.X, atb.Y, atb.Z}
+	var x [3]float64
+	for i := 0; i < 3; i++ {
+		if Abs(w[i]) < dcTruncate*wMax || w[i] == 0 {
+			continue
+		}
+		// project atb onto the eigenvector
+		k := (v[0][i]*b[0] + v[1][i]*b[1] + v[2][i]*b[2]) / w[i]
+		for j := 0; j < 3; j++ {
+			x[j] += k * v[j][i]
+		}
+	}
+	return V3{x[0], x[1], x[2]}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,437 @@
+//-----------------------------------------------------------------------------
+/*
+
+Mesh Decimation
+
+Simplify a triangle mesh with quadric error metric edge collapses.
+
+Each vertex has a quadric that gives the sum of squared distances to the
+planes of its original faces, weighted by face area. Edges are collapsed in
+order of least error, with the new vertex placed at the point that minimises
+the combined quadric. Collapses that would make the mesh non-manifold or flip
+a face are skipped.
+
+Dividing the quadric error by the total weight gives the mean squared
+distance from the new vertex to the planes. This doesn't depend on the scale
+of the model, so it is the error that is limited by MaxError.
+
+See: "Surface Simplification Using Quadric Error Metrics", Garland, Heckbert
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"container/heap"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+// DecimateParms defines the parameters for mesh decimation.
+type DecimateParms struct {
+	Triangles int     // target number of triangles (0 == no limit)
+	MaxError  float64 // maximum RMS distance from a new vertex to its original planes (0 == no limit)
+	SDF       SDF3    // source SDF3 (optional), collapses must keep the surface within MaxError of it
+}
+
+//-----------------------------------------------------------------------------
+// Quadrics
+
+// quadric is a symmetric 4x4 matrix (a2, ab, ac, ad, b2, bc, bd, c2, cd, d2).
+type quadric [10]float64
+
+// newQuadric returns the quadric for the plane n.p + d = 0 (n normalized).
+func newQuadric(n V3, d float64) quadric {
+	a, b, c := n.X, n.Y, n.Z
+	return quadric{a * a, a * b, a * c, a * d, b * b, b * c, b * d, c * c, c * d, d * d}
+}
+
+// add returns the sum of two quadrics.
+func (q quadric) add(r quadric) quadric {
+	for i := range q {
+		q[i] += r[i]
+	}
+	return q
+}
+
+// scale returns the quadric scaled by k.
+func (q quadric) scale(k float64) quadric {
+	for i := range q {
+		q[i] *= k
+	}
+	return q
+}
+
+// evaluate returns the quadric error for a point.
+func (q quadric) evaluate(v V3) float64 {
+	x, y, z := v.X, v.Y, v.Z
+	e := q[0]*x*x + 2*q[1]*x*y + 2*q[2]*x*z + 2*q[3]*x +
+		q[4]*y*y + 2*q[5]*y*z + 2*q[6]*y +
+		q[7]*z*z + 2*q[8]*z +
+		q[9]
+	return Max(e, 0)
+}
+
+// optimal returns the point that minimises the quadric error.
+// It returns false if the quadric is singular.
+func (q quadric) optimal() (V3, bool) {
+	a := M33{
+		q[0], q[1], q[2],
+		q[1], q[4], q[5],
+		q[2], q[5], q[7],
+	}
+	det := a.Determinant()
+	scale := q[0] + q[4] + q[7]
+	if Abs(det) < 1e-6*scale*scale*scale {
+		return V3{}, false
+	}
+	b := V3{-q[3], -q[6], -q[8]}
+	// Cramer's rule
+	x := M33{b.X, q[1], q[2], b.Y, q[4], q[5], b.Z, q[5], q[7]}.Determinant() / det
+	y := M33{q[0], b.X, q[2], q[1], b.Y, q[5], q[2], b.Z, q[7]}.Determinant() / det
+	z := M33{q[0], q[1], b.X, q[1], q[4], b.Y, q[2], q[5], b.Z}.Determinant() / det
+	return V3{x, y, z}, true
+}
+
+//-----------------------------------------------------------------------------
+// Edge collapse queue
+
+// collapse is a candidate edge collapse.
+type collapse struct {
+	a, b   int     // edge vertices, b is collapsed into a
+	v      V3      // new vertex position
+	cost   float64 // quadric error of the collapse
+	dist2  float64 // mean squared distance to the original planes
+	stamps [2]int  // vertex stamps when the collapse was queued
+}
+
+// less orders the collapses by cost, ties are broken by the edge vertices
+// so the result doesn't depend on the queueing order.
+func (c *collapse) less(d *collapse) bool {
+	if c.cost != d.cost {
+		return c.cost < d.cost
+	}
+	if c.a != d.a {
+		return c.a < d.a
+	}
+	return c.b < d.b
+}
+
+type collapseHeap []*collapse
+
+func (h collapseHeap) Len() int            { return len(h) }
+func (h collapseHeap) Less(i, j int) bool  { return h[i].less(h[j]) }
+func (h collapseHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *collapseHeap) Push(x interface{}) { *h = append(*h, x.(*collapse)) }
+func (h *collapseHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+//-----------------------------------------------------------------------------
+
+// decimator stores the state for a mesh decimation.
+type decimator struct {
+	k      *DecimateParms
+	v      []V3      // vertex positions
+	q      []quadric // vertex quadrics
+	w      []float64 // vertex quadric weights
+	stamp  []int     // vertex modification stamps (-1 == removed)
+	vf     [][]int   // vertex to face adjacency
+	f      [][3]int  // faces
+	alive  []bool    // face is in the mesh
+	nFaces int       // number of faces in the mesh
+	queue  collapseHeap
+}
+
+// newDecimator returns the decimation state for a mesh.
+func newDecimator(m *Mesh3, k *DecimateParms) *decimator {
+	d := decimator{
+		k:      k,
+		v:      append([]V3(nil), m.V...),
+		q:      make([]quadric, len(m.V)),
+		w:      make([]float64, len(m.V)),
+		stamp:  make([]int, len(m.V)),
+		vf:     make([][]int, len(m.V)),
+		f:      append([][3]int(nil), m.F...),
+		alive:  make([]bool, len(m.F)),
+		nFaces: len(m.F),
+	}
+	// face quadrics, weighted by face area
+	edges := make(map[[2]int]int)
+	for i, f := range d.f {
+		d.alive[i] = true
+		t := m.Triangle(i)
+		e := t.V[1].Sub(t.V[0]).Cross(t.V[2].Sub(t.V[0]))
+		area := 0.5 * e.Length()
+		n := t.Normal()
+		kp := newQuadric(n, -n.Dot(t.V[0])).scale(area)
+		for j := 0; j < 3; j++ {
+			d.q[f[j]] = d.q[f[j]].add(kp)
+			d.w[f[j]] += area
+			d.vf[f[j]] = append(d.vf[f[j]], i)
+			edges[d.edgeKey(f[j], f[(j+1)%3])]++
+		}
+	}
+	// boundary edges get a perpendicular plane to keep the boundary in place
+	for i, f := range d.f {
+		t := m.Triangle(i)
+		n := t.Normal()
+		for j := 0; j < 3; j++ {
+			a, b := f[j], f[(j+1)%3]
+			if edges[d.edgeKey(a, b)] != 1 {
+				continue
+			}
+			e := d.v[b].Sub(d.v[a])
+			bn := e.Cross(n).Normalize()
+			kp := newQuadric(bn, -bn.Dot(d.v[a])).scale(e.Length2())
+			d.q[a] = d.q[a].add(kp)
+			d.q[b] = d.q[b].add(kp)
+			d.w[a] += e.Length2()
+			d.w[b] += e.Length2()
+		}
+	}
+	// queue the initial collapses
+	for e := range edges {
+		d.push(e[0], e[1])
+	}
+	return &d
+}
+
+// edgeKey returns a key for an undirected edge.
+func (d *decimator) edgeKey(a, b int) [2]int {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int{a, b}
+}
+
+// push queues the collapse for an edge.
+func (d *decimator) push(a, b int) {
+	q := d.q[a].add(d.q[b])
+	v, ok := q.optimal()
+	if !ok {
+		// singular quadric, pick the best of the end and mid points
+		candidates := []V3{d.v[a], d.v[b], d.v[a].Add(d.v[b]).MulScalar(0.5)}
+		v = candidates[0]
+		for _, c := range candidates[1:] {
+			if q.evaluate(c) < q.evaluate(v) {
+				v = c
+			}
+		}
+	}
+	c := &collapse{
+		a:      a,
+		b:      b,
+		v:      v,
+		cost:   q.evaluate(v),
+		stamps: [2]int{d.stamp[a], d.stamp[b]},
+	}
+	if w := d.w[a] + d.w[b]; w > 0 {
+		c.dist2 = c.cost / w
+	}
+	heap.Push(&d.queue, c)
+}
+
+// neighbours returns the vertices adjacent to vertex a.
+func (d *decimator) neighbours(a int) map[int]bool {
+	n := make(map[int]bool)
+	for _, i := range d.vf[a] {
+		for _, v := range d.f[i] {
+			if v != a {
+				n[v] = true
+			}
+		}
+	}
+	return n
+}
+
+// valid returns true if collapsing the edge keeps the mesh manifold and
+// doesn't flip any faces.
+func (d *decimator) valid(c *collapse) bool {
+	// link condition: the common neighbours are the opposite vertices of the shared faces
+	na := d.neighbours(c.a)
+	nb := d.neighbours(c.b)
+	common := 0
+	for v := range na {
+		if nb[v] {
+			common++
+		}
+	}
+	shared := 0
+	for _, i := range d.vf[c.a] {
+		f := d.f[i]
+		if f[0] == c.b || f[1] == c.b || f[2] == c.b {
+			shared++
+		}
+	}
+	if common != shared {
+		return false
+	}
+	// check for flipped faces
+	for _, x := range [2]int{c.a, c.b} {
+		for _, i := range d.vf[x] {
+			f := d.f[i]
+			if f[0] == c.b && x == c.a || f[1] == c.b && x == c.a || f[2] == c.b && x == c.a {
+				// this face is removed by the collapse
+				continue
+			}
+			if x == c.b && (f[0] == c.a || f[1] == c.a || f[2] == c.a) {
+				continue
+			}
+			t0 := NewTriangle3(d.v[f[0]], d.v[f[1]], d.v[f[2]])
+			t1 := *t0
+			for j := 0; j < 3; j++ {
+				if f[j] == x {
+					t1.V[j] = c.v
+				}
+			}
+			n1 := t1.V[1].Sub(t1.V[0]).Cross(t1.V[2].Sub(t1.V[0]))
+			if n1.Length() == 0 || t0.Normal().Dot(n1.Normalize()) < 0.2 {
+				return false
+			}
+			// check the new face against the source SDF3
+			if d.k.SDF != nil && d.k.MaxError > 0 {
+				center := t1.V[0].Add(t1.V[1]).Add(t1.V[2]).DivScalar(3)
+				if Abs(d.k.SDF.Evaluate(center)) > d.k.MaxError {
+					return false
+				}
+			}
+		}
+	}
+	// check the new vertex against the source SDF3
+	if d.k.SDF != nil && d.k.MaxError > 0 {
+		if Abs(d.k.SDF.Evaluate(c.v)) > d.k.MaxError {
+			return false
+		}
+	}
+	return true
+}
+
+// apply collapses vertex b into vertex a.
+func (d *decimator) apply(c *collapse) {
+	a, b := c.a, c.b
+	d.v[a] = c.v
+	d.q[a] = d.q[a].add(d.q[b])
+	d.w[a] += d.w[b]
+	d.stamp[a]++
+	d.stamp[b] = -1
+	// update the faces
+	var faces []int
+	for _, i := range d.vf[a] {
+		if d.alive[i] {
+			faces = append(faces, i)
+		}
+	}
+	for _, i := range d.vf[b] {
+		if !d.alive[i] {
+			continue
+		}
+		f := &d.f[i]
+		if f[0] == a || f[1] == a || f[2] == a {
+			// the face is degenerate, remove it
+			d.alive[i] = false
+			d.nFaces--
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			if f[j] == b {
+				f[j] = a
+			}
+		}
+		faces = append(faces, i)
+	}
+	// remove the dead faces from the adjacency of the neighbours
+	var live []int
+	for _, i := range faces {
+		if d.alive[i] {
+			live = append(live, i)
+		}
+	}
+	d.vf[a] = live
+	d.vf[b] = nil
+	for v := range d.neighbours(a) {
+		var vf []int
+		for _, i := range d.vf[v] {
+			if d.alive[i] {
+				vf = append(vf, i)
+			}
+		}
+		d.vf[v] = vf
+		// queue the new collapses
+		d.stamp[v]++
+	}
+	for v := range d.neighbours(a) {
+		d.push(a, v)
+	}
+}
+
+// run collapses edges until the target is met.
+func (d *decimator) run() {
+	maxDist2 := math.Inf(1)
+	if d.k.MaxError > 0 {
+		maxDist2 = d.k.MaxError * d.k.MaxError
+	}
+	for d.queue.Len() > 0 {
+		if d.k.Triangles > 0 && d.nFaces <= d.k.Triangles {
+			break
+		}
+		c := heap.Pop(&d.queue).(*collapse)
+		if c.stamps[0] != d.stamp[c.a] || c.stamps[1] != d.stamp[c.b] {
+			// stale entry, one of the vertices has changed
+			continue
+		}
+		if c.dist2 > maxDist2 {
+			// too far from the original surface
+			continue
+		}
+		if d.valid(c) {
+			d.apply(c)
+		}
+	}
+}
+
+// mesh returns the decimated mesh.
+func (d *decimator) mesh() *Mesh3 {
+	m := &Mesh3{}
+	index := make([]int, len(d.v))
+	for i := range index {
+		index[i] = -1
+	}
+	for i, f := range d.f {
+		if !d.alive[i] {
+			continue
+		}
+		var g [3]int
+		for j, v := range f {
+			if index[v] < 0 {
+				index[v] = m.AddVertex(d.v[v])
+			}
+			g[j] = index[v]
+		}
+		m.AddFace(g[0], g[1], g[2])
+	}
+	return m
+}
+
+//-----------------------------------------------------------------------------
+
+// Decimate returns a simplified version of the mesh.
+// Edges are collapsed until the mesh has the target number of triangles, or
+// until no collapse is within the maximum error. Use nil parameters to
+// collapse every edge that keeps the mesh valid.
+func (m *Mesh3) Decimate(k *DecimateParms) *Mesh3 {
+	if k == nil {
+		k = &DecimateParms{}
+	}
+	d := newDecimator(m, k)
+	d.run()
+	return d.mesh()
+}
+
+//-----------------------------------------------------------------------------
@@ -3,6 +3,12 @@
 
 DXF Rendering Code
 
+Closed outlines are written as LWPOLYLINE entities, with arc segments
+(bulges) for polygon arcs and smoothed vertices. Circles and arcs are written
+as CIRCLE and ARC entities. Entities are added to the current layer, layers
+can be named (e.g. cut, engrave, score) and have a color. The drawing units
+are written to the $INSUNITS header variable.
+
 */
 //-----------------------------------------------------------------------------
 
@@ -10,11 +16,17 @@ package sdf
 
 import (
 	"fmt"
+	"image/color"
+	"io"
+	"math"
 	"sync"
 
 	"github.com/yofu/dxf"
-	"github.com/yofu/dxf/color"
+	dxfcolor "github.com/yofu/dxf/color"
 	"github.com/yofu/dxf/drawing"
+	"github.com/yofu/dxf/entity"
+	"github.com/yofu/dxf/format"
+	"github.com/yofu/dxf/insunit"
 	"github.com/yofu/dxf/table"
 )
 
@@ -24,28 +36,30 @@ import (
 type DXF struct {
 	name    string
 	drawing *drawing.Drawing
+	layer   string // current layer
 }
 
 // NewDXF returns an empty dxf drawing object.
 func NewDXF(name string) *DXF {
 	d := dxf.NewDrawing()
 	d.AddLayer("Lines", dxf.DefaultColor, dxf.DefaultLineType, true)
-	d.AddLayer("Points", color.Red, table.LT_CONTINUOUS, true)
+	d.AddLayer("Points", dxfcolor.Red, table.LT_CONTINUOUS, true)
 	return &DXF{
 		name:    name,
 		drawing: d,
+		layer:   "Lines",
 	}
 }
 
 // Line adds a line to a dxf drawing object.
 func (d *DXF) Line(p0, p1 V2) {
-	d.drawing.ChangeLayer("Lines")
+	d.drawing.ChangeLayer(d.layer)
 	d.drawing.Line(p0.X, p0.Y, 0, p1.X, p1.Y, 0)
 }
 
 // Lines adds a set of lines to a dxf drawing object.
 func (d *DXF) Lines(s V2Set) {
-	d.drawing.ChangeLayer("Lines")
+	d.drawing.ChangeLayer(d.layer)
 	p1 := s[0]
 	for i := 0; i < len(s)-1; i++ {
 		p0 := p1
@@ -67,44 +81,251 @@ func (d *DXF) Triangle(t Triangle2) {
 	d.Lines([]V2{t[0], t[1], t[2], t[0]})
 }
 
-// Save writes a dxf drawing object to a file.
-func (d *DXF) Save() error {
-	err := d.drawing.SaveAs(d.name)
-	if err != nil {
-		return err
+//-----------------------------------------------------------------------------
+// Layers and units
+
+// dxfUnits maps unit names to DXF drawing units.
+var dxfUnits = map[string]insunit.Unit{
+	"":           insunit.Unitless,
+	"mm":         insunit.Millimeters,
+	"millimeter": insunit.Millimeters,
+	"micron":     insunit.Microns,
+	"cm":         insunit.Centimeters,
+	"centimeter": insunit.Centimeters,
+	"inch":       insunit.Inches,
+	"foot":       insunit.Feet,
+	"m":          insunit.Meters,
+	"meter":      insunit.Meters,
+}
+
+// Units sets the drawing units ($INSUNITS).
+// The unit is one of "mm", "inch", "micron", "cm", "m" or "foot".
+func (d *DXF) Units(unit string) error {
+	u, ok := dxfUnits[unit]
+	if !ok {
+		return fmt.Errorf("unknown unit \"%s\"", unit)
 	}
+	d.drawing.Header().InsUnit = u
 	return nil
 }
 
+// dxfColor returns the closest AutoCAD color index for a color.
+func dxfColor(c color.Color) dxfcolor.ColorNumber {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	best, index := math.Inf(1), 7
+	// skip 0 (by block) and 7 (black/white depending on the background)
+	for i := 1; i < 256; i++ {
+		rgb := dxfcolor.ColorRGB[i]
+		if i == 7 {
+			continue
+		}
+		dr := float64(rgb[0]) - float64(n.R)
+		dg := float64(rgb[1]) - float64(n.G)
+		db := float64(rgb[2]) - float64(n.B)
+		if e := dr*dr + dg*dg + db*db; e < best {
+			best, index = e, i
+		}
+	}
+	return dxfcolor.ColorNumber(index)
+}
+
+// Layer adds a named layer with a color and makes it the current layer.
+// Entities are added to the current layer. Use a nil color for the default
+// (black/white) color. An existing layer is made current.
+func (d *DXF) Layer(name string, c color.Color) {
+	cl := dxfcolor.ColorNumber(dxf.DefaultColor)
+	if c != nil {
+		cl = dxfColor(c)
+	}
+	d.drawing.AddLayer(name, cl, dxf.DefaultLineType, true)
+	d.layer = name
+}
+
 //-----------------------------------------------------------------------------
+// Polylines, arcs and circles
 
-// SaveDXF writes line segments to a DXF file.
-func SaveDXF(path string, mesh []*Line) error {
-	d := NewDXF(path)
+// dxfPolyline is an LWPOLYLINE entity with arc segments.
+type dxfPolyline struct {
+	*entity.LwPolyline
+	bulge []float64
+}
+
+// Format writes an LWPOLYLINE with the bulge (group code 42) after each vertex.
+func (p *dxfPolyline) Format(f format.Formatter) {
+	p.LwPolyline.Format(&dxfBulgeFormatter{Formatter: f, bulge: p.bulge})
+}
+
+// dxfBulgeFormatter adds bulges after the vertex y values (group code 20).
+type dxfBulgeFormatter struct {
+	format.Formatter
+	bulge []float64
+	n     int
+}
+
+// WriteFloat writes a float value.
+func (f *dxfBulgeFormatter) WriteFloat(num int, val float64) {
+	f.Formatter.WriteFloat(num, val)
+	if num == 20 && f.n < len(f.bulge) {
+		if f.bulge[f.n] != 0 {
+			f.Formatter.WriteFloat(42, f.bulge[f.n])
+		}
+		f.n++
+	}
+}
+
+// polyline adds a polyline with arc segments to a dxf drawing object.
+func (d *DXF) polyline(pl *polyline) {
+	d.drawing.ChangeLayer(d.layer)
+	l := entity.NewLwPolyline(len(pl.points))
+	for i, p := range pl.points {
+		l.Vertices[i] = []float64{p.X, p.Y}
+	}
+	if pl.closed {
+		l.Close()
+	}
+	l.SetLayer(d.drawing.CurrentLayer)
+	d.drawing.AddEntity(&dxfPolyline{LwPolyline: l, bulge: pl.bulge})
+}
+
+// Polyline adds an LWPOLYLINE to a dxf drawing object.
+func (d *DXF) Polyline(s V2Set, closed bool) {
+	d.polyline(&polyline{points: s, bulge: make([]float64, len(s)), closed: closed})
+}
+
+// Polygon adds a polygon to a dxf drawing object.
+// Arcs and smoothed vertices are written as polyline arc segments.
+func (d *DXF) Polygon(p *Polygon) {
+	for _, pl := range p.polylines() {
+		d.polyline(pl)
+	}
+}
+
+// Circle adds a circle to a dxf drawing object.
+func (d *DXF) Circle(c V2, r float64) {
+	d.drawing.ChangeLayer(d.layer)
+	d.drawing.Circle(c.X, c.Y, 0, r)
+}
+
+// Arc adds a counter-clockwise arc to a dxf drawing object.
+// The start and end angles are in radians.
+func (d *DXF) Arc(c V2, r, start, end float64) {
+	d.drawing.ChangeLayer(d.layer)
+	d.drawing.Arc(c.X, c.Y, 0, r, RtoD(start), RtoD(end))
+}
+
+// contourCircle returns the circle for a contour if all the points are
+// within the tolerance of the circle.
+func contourCircle(c V2Set, tolerance float64) (V2, float64, bool) {
+	if len(c) < 8 {
+		return V2{}, 0, false
+	}
+	// area centroid
+	var a float64
+	var center V2
+	for i := range c {
+		p0, p1 := c[i], c[(i+1)%len(c)]
+		k := p0.X*p1.Y - p1.X*p0.Y
+		a += k
+		center = center.Add(p0.Add(p1).MulScalar(k))
+	}
+	if a == 0 {
+		return V2{}, 0, false
+	}
+	center = center.DivScalar(3 * a)
+	var r float64
+	for _, p := range c {
+		r += p.Sub(center).Length()
+	}
+	r /= float64(len(c))
+	for _, p := range c {
+		if Abs(p.Sub(center).Length()-r) > tolerance {
+			return V2{}, 0, false
+		}
+	}
+	return center, r, true
+}
+
+// Contours adds a tree of contours to a dxf drawing object.
+// Contours within the tolerance of a circle are written as circles,
+// the others are written as closed polylines.
+func (d *DXF) Contours(contours []*Contour, tolerance float64) {
+	for _, c := range FlattenContours(contours) {
+		if center, r, ok := contourCircle(c.Points, tolerance); ok {
+			d.Circle(center, r)
+			continue
+		}
+		d.Polyline(c.Points, true)
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// Encode writes a dxf drawing object to a writer.
+func (d *DXF) Encode(w io.Writer) error {
+	_, err := d.drawing.WriteTo(w)
+	return err
+}
+
+// Save writes a dxf drawing object to a file.
+func (d *DXF) Save() error {
+	return saveFile(d.name, d.Encode)
+}
+
+//-----------------------------------------------------------------------------
+
+// newLinesDXF returns a dxf drawing object for a set of line segments.
+func newLinesDXF(name string, mesh []*Line) *DXF {
+	d := NewDXF(name)
 	d.drawing.ChangeLayer("Lines")
 	for i := range mesh {
 		p0 := mesh[i][0]
 		p1 := mesh[i][1]
 		d.drawing.Line(p0.X, p0.Y, 0, p1.X, p1.Y, 0)
 	}
-	err := d.Save()
-	if err != nil {
-		return err
-	}
-	return nil
+	return d
+}
+
+// EncodeDXF writes line segments in DXF format to a writer.
+func EncodeDXF(w io.Writer, mesh []*Line) error {
+	return newLinesDXF("", mesh).Encode(w)
+}
+
+// SaveDXF writes line segments to a DXF file.
+func SaveDXF(path string, mesh []*Line) error {
+	return newLinesDXF(path, mesh).Save()
 }
 
 //-----------------------------------------------------------------------------
 
+// WriteDXFTo writes a stream of line segments in DXF format to a writer.
+// The drawing is written when the channel is closed. The write error (nil on
+// success) is sent on the error channel when the writing is complete.
+func WriteDXFTo(wg *sync.WaitGroup, w io.Writer) (chan<- *Line, <-chan error) {
+	return writeDXF(wg, func(d *DXF) error {
+		return d.Encode(w)
+	})
+}
+
 // WriteDXF writes a stream of line segments to a DXF file.
+// Write errors are printed, use WriteDXFTo to handle them.
 func WriteDXF(wg *sync.WaitGroup, path string) (chan<- *Line, error) {
+	c, errc := writeDXF(wg, func(d *DXF) error {
+		return saveFile(path, d.Encode)
+	})
+	printWriteError(wg, errc)
+	return c, nil
+}
+
+// writeDXF collects a stream of line segments and writes them with the save function.
+func writeDXF(wg *sync.WaitGroup, save func(d *DXF) error) (chan<- *Line, <-chan error) {
 
-	d := NewDXF(path)
+	d := NewDXF("")
 	d.drawing.ChangeLayer("Lines")
 
 	// External code writes line segments to this channel.
 	// This goroutine reads the channel and writes line segments to the file.
 	c := make(chan *Line)
+	errc := make(chan error, 1)
 
 	wg.Add(1)
 	go func() {
@@ -114,14 +335,10 @@ func WriteDXF(wg *sync.WaitGroup, path string) (chan<- *Line, error) {
 			p1 := l[1]
 			d.drawing.Line(p0.X, p0.Y, 0, p1.X, p1.Y, 0)
 		}
-		err := d.Save()
-		if err != nil {
-			fmt.Printf("%s\n", err)
-			return
-		}
+		errc <- save(d)
 	}()
 
-	return c, nil
+	return c, errc
 }
 
 //-----------------------------------------------------------------------------
@@ -0,0 +1,524 @@
+//-----------------------------------------------------------------------------
+/*
+
+DXF Import
+
+Reads 2D profiles from the ENTITIES section of a DXF file. LINE, LWPOLYLINE
+(with bulges), POLYLINE, ARC, CIRCLE, ELLIPSE and SPLINE entities are turned
+into line segments, curves are flattened to within a given error. The end
+points of the entities are snapped together and the segments are joined into
+closed loops. Loops within loops are holes.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+
+// DXFLoadParms defines the parameters for reading a DXF file.
+type DXFLoadParms struct {
+	Layers    []string // layers to read (nil == all layers)
+	Tolerance float64  // end points closer than this are joined (default 1e-3)
+	MaxError  float64  // maximum distance from a curve to its line segments (default 1e-2)
+}
+
+// tolerance returns the end point tolerance.
+func (k *DXFLoadParms) tolerance() float64 {
+	if k.Tolerance <= 0 {
+		return 1e-3
+	}
+	return k.Tolerance
+}
+
+// maxError returns the curve flattening error.
+func (k *DXFLoadParms) maxError() float64 {
+	if k.MaxError <= 0 {
+		return 1e-2
+	}
+	return k.MaxError
+}
+
+// layer returns true if entities on a layer should be read.
+func (k *DXFLoadParms) layer(name string) bool {
+	if k.Layers == nil {
+		return true
+	}
+	for _, l := range k.Layers {
+		if strings.EqualFold(l, name) {
+			return true
+		}
+	}
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// group codes
+
+// dxfGroup is a DXF group code and value.
+type dxfGroup struct {
+	code  int
+	value string
+}
+
+// float returns the value of a group as a float.
+func (g *dxfGroup) float() float64 {
+	x, _ := strconv.ParseFloat(g.value, 64)
+	return x
+}
+
+// int returns the value of a group as an integer.
+func (g *dxfGroup) int() int {
+	x, _ := strconv.Atoi(g.value)
+	return x
+}
+
+// dxfEntity is an entity type and its groups.
+type dxfEntity struct {
+	kind   string
+	groups []dxfGroup
+}
+
+// float returns the value of the first group with a code.
+func (e *dxfEntity) float(code int, def float64) float64 {
+	for i := range e.groups {
+		if e.groups[i].code == code {
+			return e.groups[i].float()
+		}
+	}
+	return def
+}
+
+// int returns the value of the first group with a code.
+func (e *dxfEntity) int(code int) int {
+	for i := range e.groups {
+		if e.groups[i].code == code {
+			return e.groups[i].int()
+		}
+	}
+	return 0
+}
+
+// layer returns the layer name of an entity.
+func (e *dxfEntity) layer() string {
+	for i := range e.groups {
+		if e.groups[i].code == 8 {
+			return e.groups[i].value
+		}
+	}
+	return "0"
+}
+
+// points returns the points with the x and y group codes.
+func (e *dxfEntity) points(xcode, ycode int) V2Set {
+	var s V2Set
+	for _, g := range e.groups {
+		switch g.code {
+		case xcode:
+			s = append(s, V2{g.float(), 0})
+		case ycode:
+			if len(s) > 0 {
+				s[len(s)-1].Y = g.float()
+			}
+		}
+	}
+	return s
+}
+
+// ocs maps points from the object coordinate system to the xy plane.
+// 2D entities drawn from below have an extrusion direction of -z,
+// which mirrors the x axis.
+func (e *dxfEntity) ocs(s V2Set) V2Set {
+	if e.float(230, 1) < 0 {
+		for i := range s {
+			s[i].X = -s[i].X
+		}
+	}
+	return s
+}
+
+// readDXFEntities returns the entities in the ENTITIES section.
+func readDXFEntities(r io.Reader) ([]*dxfEntity, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var entities []*dxfEntity
+	var e *dxfEntity
+	section, inEntities := "", false
+	line := 0
+	for scanner.Scan() {
+		line++
+		code, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			return nil, fmt.Errorf("bad group code on line %d", line)
+		}
+		if !scanner.Scan() {
+			return nil, fmt.Errorf("missing group value on line %d", line+1)
+		}
+		line++
+		g := dxfGroup{code, strings.TrimSpace(scanner.Text())}
+		switch {
+		case g.code == 0:
+			e = nil
+			switch g.value {
+			case "SECTION":
+				section = ""
+			case "ENDSEC":
+				inEntities = false
+			case "EOF":
+				return entities, nil
+			default:
+				if inEntities {
+					e = &dxfEntity{kind: g.value}
+					entities = append(entities, e)
+				}
+			}
+		case g.code == 2 && section == "" && e == nil:
+			section = g.value
+			inEntities = section == "ENTITIES"
+		case e != nil:
+			e.groups = append(e.groups, g)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+//-----------------------------------------------------------------------------
+// curves
+
+// maximum number of line segments for an arc
+const arcMaxSegments = 1 << 16
+
+// arcSegments returns the number of line segments for an arc (at least 1).
+func arcSegments(radius, angle, maxError float64) int {
+	if radius <= maxError {
+		return 1
+	}
+	step := 2 * math.Acos(1-maxError/radius)
+	n := math.Max(math.Ceil(Abs(angle)/step), math.Ceil(4*Abs(angle)/Tau))
+	if !(n >= 1) {
+		// zero angle or bad input (NaN)
+		return 1
+	}
+	return int(math.Min(n, arcMaxSegments))
+}
+
+// arcPoints returns the points on an arc, including the end points.
+func arcPoints(c V2, radius, start, angle, maxError float64) V2Set {
+	n := arcSegments(radius, angle, maxError)
+	s := make(V2Set, n+1)
+	for i := range s {
+		a := start + angle*float64(i)/float64(n)
+		s[i] = c.Add(V2{math.Cos(a), math.Sin(a)}.MulScalar(radius))
+	}
+	return s
+}
+
+// bulgePoints returns the points on a polyline arc segment from a to b,
+// not including a. The bulge is tan(theta/4) for the included angle theta.
+func bulgePoints(a, b V2, bulge, maxError float64) V2Set {
+	chord := b.Sub(a)
+	if bulge == 0 || chord.Length() == 0 {
+		return V2Set{b}
+	}
+	theta := 4 * math.Atan(bulge)
+	radius := Abs(0.5 * chord.Length() / math.Sin(0.5*theta))
+	// the center is to the left of the chord for counter-clockwise arcs < 180 degrees
+	n := V2{-chord.Y, chord.X}.Normalize()
+	c := a.Add(b).MulScalar(0.5).Add(n.MulScalar(0.5 * chord.Length() / math.Tan(0.5*theta)))
+	ac := a.Sub(c)
+	s := arcPoints(c, radius, math.Atan2(ac.Y, ac.X), theta, maxError)
+	s[len(s)-1] = b
+	return s[1:]
+}
+
+// ellipsePoints returns the points on an elliptical arc.
+func ellipsePoints(c, major V2, ratio, start, end, maxError float64) V2Set {
+	if end <= start {
+		end += Tau
+	}
+	minor := V2{-major.Y, major.X}.MulScalar(ratio)
+	// flatten as for a circle with the major radius
+	n := arcSegments(major.Length(), end-start, maxError)
+	s := make(V2Set, n+1)
+	for i := range s {
+		t := start + (end-start)*float64(i)/float64(n)
+		s[i] = c.Add(major.MulScalar(math.Cos(t))).Add(minor.MulScalar(math.Sin(t)))
+	}
+	return s
+}
+
+// splinePoints returns the points on a (rational) b-spline.
+// See: https://en.wikipedia.org/wiki/De_Boor%27s_algorithm
+func splinePoints(degree int, knots []float64, ctrl V2Set, weights []float64, maxError float64) V2Set {
+	n := len(ctrl)
+	if degree < 1 || n <= degree || len(knots) != n+degree+1 {
+		return ctrl
+	}
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = 1
+		if len(weights) == n && weights[i] > 0 {
+			w[i] = weights[i]
+		}
+	}
+	// homogeneous control points
+	type hp struct{ x, y, w float64 }
+	// eval evaluates the spline at t in the knot span k.
+	eval := func(k int, t float64) V2 {
+		d := make([]hp, degree+1)
+		for j := range d {
+			p := ctrl[j+k-degree]
+			wj := w[j+k-degree]
+			d[j] = hp{p.X * wj, p.Y * wj, wj}
+		}
+		for r := 1; r <= degree; r++ {
+			for j := degree; j >= r; j-- {
+				i := j + k - degree
+				den := knots[i+degree+1-r] - knots[i]
+				a := 0.0
+				if den != 0 {
+					a = (t - knots[i]) / den
+				}
+				d[j] = hp{(1-a)*d[j-1].x + a*d[j].x, (1-a)*d[j-1].y + a*d[j].y, (1-a)*d[j-1].w + a*d[j].w}
+			}
+		}
+		return V2{d[degree].x / d[degree].w, d[degree].y / d[degree].w}
+	}
+	// Sample each knot span. The number of samples assumes the radius of
+	// curvature is about the length of the control polygon for the span.
+	var s V2Set
+	for k := degree; k < n; k++ {
+		t0, t1 := knots[k], knots[k+1]
+		if t1 <= t0 {
+			continue
+		}
+		var length float64
+		for j := k - degree; j < k; j++ {
+			length += ctrl[j+1].Sub(ctrl[j]).Length()
+		}
+		steps := int(Clamp(math.Ceil(math.Sqrt(length/(8*maxError))), 2, 256))
+		if s == nil {
+			s = V2Set{eval(k, t0)}
+		}
+		for i := 1; i <= steps; i++ {
+			s = append(s, eval(k, t0+(t1-t0)*float64(i)/float64(steps)))
+		}
+	}
+	return s
+}
+
+//-----------------------------------------------------------------------------
+
+// dxfPaths returns the point paths for the entities.
+// Closed paths repeat the first point.
+func dxfPaths(entities []*dxfEntity, k *DXFLoadParms) ([]V2Set, error) {
+	maxError := k.maxError()
+	var paths []V2Set
+	for i := 0; i < len(entities); i++ {
+		e := entities[i]
+		if !k.layer(e.layer()) {
+			continue
+		}
+		switch e.kind {
+		case "LINE":
+			paths = append(paths, V2Set{
+				{e.float(10, 0), e.float(20, 0)},
+				{e.float(11, 0), e.float(21, 0)},
+			})
+		case "LWPOLYLINE":
+			// the bulge follows its vertex
+			var v V2Set
+			var bulge []float64
+			for _, g := range e.groups {
+				switch g.code {
+				case 10:
+					v = append(v, V2{g.float(), 0})
+					bulge = append(bulge, 0)
+				case 20:
+					if len(v) == 0 {
+						return nil, errors.New("LWPOLYLINE y coordinate without an x coordinate")
+					}
+					v[len(v)-1].Y = g.float()
+				case 42:
+					if len(bulge) > 0 {
+						bulge[len(bulge)-1] = g.float()
+					}
+				}
+			}
+			paths = append(paths, e.ocs(polylinePoints(v, bulge, e.int(70)&1 != 0, maxError)))
+		case "POLYLINE":
+			// followed by VERTEX entities and a SEQEND
+			var v V2Set
+			var bulge []float64
+			for i+1 < len(entities) && entities[i+1].kind == "VERTEX" {
+				i++
+				x := entities[i]
+				v = append(v, V2{x.float(10, 0), x.float(20, 0)})
+				bulge = append(bulge, x.float(42, 0))
+			}
+			paths = append(paths, e.ocs(polylinePoints(v, bulge, e.int(70)&1 != 0, maxError)))
+		case "CIRCLE":
+			c := V2{e.float(10, 0), e.float(20, 0)}
+			paths = append(paths, e.ocs(arcPoints(c, e.float(40, 0), 0, Tau, maxError)))
+		case "ARC":
+			c := V2{e.float(10, 0), e.float(20, 0)}
+			start, end := DtoR(e.float(50, 0)), DtoR(e.float(51, 0))
+			if end <= start {
+				end += Tau
+			}
+			paths = append(paths, e.ocs(arcPoints(c, e.float(40, 0), start, end-start, maxError)))
+		case "ELLIPSE":
+			c := V2{e.float(10, 0), e.float(20, 0)}
+			major := V2{e.float(11, 0), e.float(21, 0)}
+			ratio := e.float(40, 1)
+			if e.float(230, 1) < 0 {
+				// the minor axis is extrusion x major
+				ratio = -ratio
+			}
+			paths = append(paths, ellipsePoints(c, major, ratio, e.float(41, 0), e.float(42, Tau), maxError))
+		case "SPLINE":
+			var knots, weights []float64
+			for _, g := range e.groups {
+				switch g.code {
+				case 40:
+					knots = append(knots, g.float())
+				case 41:
+					weights = append(weights, g.float())
+				}
+			}
+			ctrl := e.points(10, 20)
+			if len(ctrl) == 0 {
+				// fit points only, use them as a polyline
+				ctrl = e.points(11, 21)
+				knots = nil
+			}
+			s := splinePoints(e.int(71), knots, ctrl, weights, maxError)
+			if e.int(70)&1 != 0 && len(s) > 0 && !s[0].Equals(s[len(s)-1], tolerance) {
+				s = append(s, s[0])
+			}
+			paths = append(paths, s)
+		}
+	}
+	return paths, nil
+}
+
+// polylinePoints returns the points of a polyline with arc segments.
+func polylinePoints(v V2Set, bulge []float64, closed bool, maxError float64) V2Set {
+	if len(v) == 0 {
+		return nil
+	}
+	s := V2Set{v[0]}
+	n := len(v) - 1
+	if closed {
+		n = len(v)
+	}
+	for i := 0; i < n; i++ {
+		s = append(s, bulgePoints(v[i], v[(i+1)%len(v)], bulge[i], maxError)...)
+	}
+	return s
+}
+
+// dxfLines snaps the end points of the paths together and returns their line segments.
+func dxfLines(paths []V2Set, tol float64) []*Line {
+	// end points within the tolerance are replaced with the first end point found
+	snap := make(map[contourKey][]V2)
+	find := func(p V2) V2 {
+		k := newContourKey(p, tol)
+		for x := k.x - 1; x <= k.x+1; x++ {
+			for y := k.y - 1; y <= k.y+1; y++ {
+				for _, q := range snap[contourKey{x, y}] {
+					if q.Sub(p).Length() <= tol {
+						return q
+					}
+				}
+			}
+		}
+		snap[k] = append(snap[k], p)
+		return p
+	}
+	var lines []*Line
+	for _, s := range paths {
+		if len(s) < 2 {
+			continue
+		}
+		s[0] = find(s[0])
+		s[len(s)-1] = find(s[len(s)-1])
+		for i := 0; i < len(s)-1; i++ {
+			if !s[i].Equals(s[i+1], 0) {
+				lines = append(lines, &Line{s[i], s[i+1]})
+			}
+		}
+	}
+	return lines
+}
+
+//-----------------------------------------------------------------------------
+
+// DecodeDXF reads the closed loops of a 2D DXF drawing.
+// The top level loops are returned, the loops they enclose are their children.
+// Entities that aren't part of a closed loop are ignored.
+func DecodeDXF(r io.Reader, k *DXFLoadParms) ([]*Contour, error) {
+	if k == nil {
+		k = &DXFLoadParms{}
+	}
+	entities, err := readDXFEntities(r)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := dxfPaths(entities, k)
+	if err != nil {
+		return nil, err
+	}
+	// the end points are snapped, so join them with a smaller tolerance
+	tol := k.tolerance()
+	roots := Contours(dxfLines(paths, tol), 1e-2*tol)
+	if len(roots) == 0 {
+		return nil, errors.New("no closed loops in DXF file")
+	}
+	return roots, nil
+}
+
+// LoadDXF reads the closed loops of a 2D DXF file.
+func LoadDXF(path string, k *DXFLoadParms) ([]*Contour, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeDXF(f, k)
+}
+
+// DecodeDXF2D reads a 2D DXF drawing and returns an SDF2 for the closed loops.
+func DecodeDXF2D(r io.Reader, k *DXFLoadParms) (SDF2, error) {
+	roots, err := DecodeDXF(r, k)
+	if err != nil {
+		return nil, err
+	}
+	return ContourPolygon2D(roots), nil
+}
+
+// LoadDXF2D reads a 2D DXF file and returns an SDF2 for the closed loops.
+func LoadDXF2D(path string, k *DXFLoadParms) (SDF2, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeDXF2D(f, k)
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,451 @@
+//-----------------------------------------------------------------------------
+/*
+
+FDM G-Code Generation (Experimental)
+
+A simple slicer for FDM printers that works directly on an SDF3.
+
+Each layer is a Slice2D of the SDF3 at the middle of the layer. The
+perimeters are the contours (marching squares) of the slice inset with
+Offset2D. The region inside the perimeters is filled with rectilinear infill,
+the infill lines are clipped against the inset slice by stepping along them
+with the distance field. The bottom and top layers are solid.
+
+The output is Marlin flavoured G-code with relative extrusion.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+// GCodeParms defines the parameters for G-code generation.
+type GCodeParms struct {
+	Nozzle           float64     // nozzle diameter (mm), default 0.4
+	Filament         float64     // filament diameter (mm), default 1.75
+	LayerHeight      float64     // layer height (mm), default 0.2
+	FirstLayerHeight float64     // first layer height (mm), default LayerHeight
+	ExtrusionWidth   float64     // extrusion width (mm), default 1.125 * Nozzle
+	Perimeters       int         // number of perimeters, default 2 (< 0 == none)
+	SolidLayers      int         // number of solid bottom and top layers, default 3 (< 0 == none)
+	InfillDensity    float64     // infill density 0..1 (0 == no infill)
+	InfillAngle      float64     // infill angle (degrees), alternate layers are rotated by 90 degrees
+	PrintSpeed       float64     // print speed (mm/s), default 40
+	FirstLayerSpeed  float64     // first layer print speed (mm/s), default 20
+	TravelSpeed      float64     // travel speed (mm/s), default 120
+	Retraction       float64     // retraction length (mm) for travel moves (0 == none)
+	RetractSpeed     float64     // retraction speed (mm/s), default 40
+	NozzleTemp       float64     // nozzle temperature (C), 0 == not set
+	BedTemp          float64     // bed temperature (C), 0 == not set
+	Fan              float64     // part cooling fan 0..1 after the first layer
+	Origin           V2          // bed position for the center of the part
+	Workers          int         // number of slicing workers (0 == runtime.NumCPU())
+	Logger           *log.Logger // progress logger (nil == quiet)
+}
+
+// logf writes a message to the slicing logger.
+func (k *GCodeParms) logf(format string, args ...interface{}) {
+	if k.Logger != nil {
+		k.Logger.Printf(format, args...)
+	}
+}
+
+// defaults returns the parameters with the default values set.
+func (k GCodeParms) defaults() (*GCodeParms, error) {
+	def := func(x *float64, v float64) {
+		if *x == 0 {
+			*x = v
+		}
+	}
+	def(&k.Nozzle, 0.4)
+	def(&k.Filament, 1.75)
+	def(&k.LayerHeight, 0.2)
+	def(&k.FirstLayerHeight, k.LayerHeight)
+	def(&k.ExtrusionWidth, 1.125*k.Nozzle)
+	def(&k.PrintSpeed, 40)
+	def(&k.FirstLayerSpeed, 20)
+	def(&k.TravelSpeed, 120)
+	def(&k.RetractSpeed, 40)
+	// zero is the default, negative is none
+	defInt := func(x *int, v int) {
+		if *x == 0 {
+			*x = v
+		} else if *x < 0 {
+			*x = 0
+		}
+	}
+	defInt(&k.Perimeters, 2)
+	defInt(&k.SolidLayers, 3)
+	if k.Nozzle < 0 || k.Filament < 0 || k.LayerHeight < 0 || k.FirstLayerHeight < 0 || k.ExtrusionWidth < 0 {
+		return nil, errors.New("dimensions must be > 0")
+	}
+	if k.PrintSpeed < 0 || k.FirstLayerSpeed < 0 || k.TravelSpeed < 0 || k.RetractSpeed < 0 {
+		return nil, errors.New("speeds must be > 0")
+	}
+	if k.InfillDensity < 0 || k.InfillDensity > 1 {
+		return nil, errors.New("InfillDensity must be 0..1")
+	}
+	return &k, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// gcodeLayer is the toolpath for a layer.
+type gcodeLayer struct {
+	z          float64 // height of the top of the layer
+	height     float64 // layer height
+	perimeters []V2Set // closed loops, outermost first
+	infill     [][2]V2 // infill lines in print order
+}
+
+// gcodeSlicer slices an SDF3 into layers.
+type gcodeSlicer struct {
+	s      SDF3
+	k      *GCodeParms
+	offset V2 // xy offset from the model to the bed
+	z0     float64
+	layers []float64 // height of the top of each layer above the bed
+}
+
+// newGCodeSlicer returns a slicer for an SDF3.
+func newGCodeSlicer(s SDF3, k *GCodeParms) (*gcodeSlicer, error) {
+	if k == nil {
+		return nil, errors.New("no G-code parameters")
+	}
+	k, err := k.defaults()
+	if err != nil {
+		return nil, err
+	}
+	bb := s.BoundingBox()
+	sl := &gcodeSlicer{
+		s:      s,
+		k:      k,
+		offset: k.Origin.Sub(V2{bb.Center().X, bb.Center().Y}),
+		z0:     bb.Min.Z,
+	}
+	height := bb.Size().Z
+	for z := k.FirstLayerHeight; z < height+0.5*k.LayerHeight; z += k.LayerHeight {
+		sl.layers = append(sl.layers, z)
+	}
+	if len(sl.layers) == 0 {
+		return nil, errors.New("part is thinner than the first layer")
+	}
+	return sl, nil
+}
+
+// contours returns the closed contours of an SDF2.
+func (sl *gcodeSlicer) contours(s SDF2) []V2Set {
+	w := sl.k.ExtrusionWidth
+	if size := s.BoundingBox().Size(); size.X <= 0 || size.Y <= 0 {
+		return nil
+	}
+	lines := marchingSquaresQuadtreeLines(s, 0.25*w, 4, nil)
+	closed, _ := chainLines(lines, 1e-3*w)
+	var loops []V2Set
+	for _, c := range closed {
+		c = simplifyContour(c, 0.01*w, true)
+		if len(c) >= 3 {
+			loops = append(loops, c)
+		}
+	}
+	return loops
+}
+
+// clip returns the parts of the line from a to b inside an SDF2.
+func (sl *gcodeSlicer) clip(s SDF2, a, b V2) [][2]V2 {
+	minStep := 0.25 * sl.k.ExtrusionWidth
+	length := b.Sub(a).Length()
+	v := b.Sub(a).DivScalar(length)
+	eval := func(t float64) float64 { return s.Evaluate(a.Add(v.MulScalar(t))) }
+	// crossing finds the boundary between t0 and t1 by bisection
+	crossing := func(t0, t1 float64, inside bool) float64 {
+		for i := 0; i < 20; i++ {
+			t := 0.5 * (t0 + t1)
+			if (eval(t) <= 0) == inside {
+				t0 = t
+			} else {
+				t1 = t
+			}
+		}
+		return 0.5 * (t0 + t1)
+	}
+	var out [][2]V2
+	var start float64
+	inside := false
+	t0 := 0.0
+	for t := 0.0; ; {
+		d := eval(t)
+		if (d <= 0) != inside {
+			tc := crossing(t0, t, inside)
+			if inside {
+				out = append(out, [2]V2{a.Add(v.MulScalar(start)), a.Add(v.MulScalar(tc))})
+			} else {
+				start = tc
+			}
+			inside = !inside
+		}
+		if t >= length {
+			break
+		}
+		t0 = t
+		t = math.Min(t+math.Max(Abs(d), minStep), length)
+	}
+	if inside {
+		out = append(out, [2]V2{a.Add(v.MulScalar(start)), b})
+	}
+	// drop very short segments
+	var lines [][2]V2
+	for _, l := range out {
+		if l[1].Sub(l[0]).Length() > sl.k.ExtrusionWidth {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// infill returns the rectilinear infill lines for a region.
+func (sl *gcodeSlicer) infill(s SDF2, density, angle float64) [][2]V2 {
+	if density <= 0 {
+		return nil
+	}
+	spacing := sl.k.ExtrusionWidth / density
+	dir := V2{math.Cos(angle), math.Sin(angle)}
+	n := V2{-dir.Y, dir.X}
+	// project the bounding box onto the scan direction and normal
+	v := s.BoundingBox().Vertices()
+	tMin, tMax := math.Inf(1), math.Inf(-1)
+	sMin, sMax := math.Inf(1), math.Inf(-1)
+	for _, p := range v {
+		tMin, tMax = math.Min(tMin, p.Dot(dir)), math.Max(tMax, p.Dot(dir))
+		sMin, sMax = math.Min(sMin, p.Dot(n)), math.Max(sMax, p.Dot(n))
+	}
+	// scan lines on a fixed grid so the lines line up across layers
+	var lines [][2]V2
+	reverse := false
+	for x := math.Ceil(sMin/spacing) * spacing; x <= sMax; x += spacing {
+		a := n.MulScalar(x).Add(dir.MulScalar(tMin))
+		b := n.MulScalar(x).Add(dir.MulScalar(tMax))
+		segs := sl.clip(s, a, b)
+		// zig-zag between scan lines
+		if reverse {
+			for i, j := 0, len(segs)-1; i < j; i, j = i+1, j-1 {
+				segs[i], segs[j] = segs[j], segs[i]
+			}
+			for i := range segs {
+				segs[i][0], segs[i][1] = segs[i][1], segs[i][0]
+			}
+		}
+		lines = append(lines, segs...)
+		reverse = !reverse
+	}
+	return lines
+}
+
+// layer returns the toolpath for a layer.
+func (sl *gcodeSlicer) layer(i int) *gcodeLayer {
+	k := sl.k
+	w := k.ExtrusionWidth
+	l := &gcodeLayer{z: sl.layers[i], height: k.LayerHeight}
+	if i == 0 {
+		l.height = k.FirstLayerHeight
+	}
+	// slice at the middle of the layer
+	z := sl.z0 + l.z - 0.5*l.height
+	outline := sl.contours(Slice2D(sl.s, V3{0, 0, z}, V3{0, 0, 1}))
+	// the slice distance is wrong near the top and bottom of the part,
+	// so the insets are taken from the exact distance to the outline
+	s, err := newContourSDF2(outline)
+	if err != nil {
+		// nothing in this layer
+		return l
+	}
+	// perimeters
+	for j := 0; j < k.Perimeters; j++ {
+		l.perimeters = append(l.perimeters, sl.contours(Offset2D(s, -(0.5+float64(j))*w))...)
+	}
+	// infill, overlapping the inner perimeter by 1/4 of the extrusion width
+	density := k.InfillDensity
+	if i < k.SolidLayers || i >= len(sl.layers)-k.SolidLayers {
+		density = 1
+	}
+	angle := DtoR(k.InfillAngle)
+	if i%2 == 1 {
+		angle += 0.5 * Pi
+	}
+	inset := Offset2D(s, -(float64(k.Perimeters)+0.25)*w)
+	if k.Perimeters == 0 {
+		inset = Offset2D(s, -0.5*w)
+	}
+	l.infill = sl.infill(inset, density, angle)
+	return l
+}
+
+// toolpaths returns the toolpaths for all layers, generated in parallel.
+func (sl *gcodeSlicer) toolpaths() []*gcodeLayer {
+	layers := make([]*gcodeLayer, len(sl.layers))
+	parallelFor(sl.k.Workers, len(layers), func(i int) {
+		layers[i] = sl.layer(i)
+	})
+	return layers
+}
+
+//-----------------------------------------------------------------------------
+
+// gcodeWriter writes G-code moves.
+type gcodeWriter struct {
+	w        *bufio.Writer
+	k        *GCodeParms
+	pos      V2
+	feed     float64 // current feed rate (mm/min)
+	retract  bool    // is the filament retracted?
+	ePerMM   float64 // extrusion per mm of travel
+	speed    float64 // print speed (mm/s)
+	offset   V2
+	distance float64 // total extrusion distance
+}
+
+// setFeed sets the feed rate for the next move.
+func (g *gcodeWriter) setFeed(speed float64) string {
+	f := 60 * speed
+	if f == g.feed {
+		return ""
+	}
+	g.feed = f
+	return fmt.Sprintf(" F%.0f", f)
+}
+
+// travel moves to a point without extruding.
+func (g *gcodeWriter) travel(p V2) {
+	p = p.Add(g.offset)
+	if p.Equals(g.pos, 1e-6) {
+		return
+	}
+	if g.k.Retraction > 0 && !g.retract && p.Sub(g.pos).Length() > 2*g.k.ExtrusionWidth {
+		fmt.Fprintf(g.w, "G1 E%.5f F%.0f\n", -g.k.Retraction, 60*g.k.RetractSpeed)
+		g.feed = 60 * g.k.RetractSpeed
+		g.retract = true
+	}
+	fmt.Fprintf(g.w, "G0 X%.3f Y%.3f%s\n", p.X, p.Y, g.setFeed(g.k.TravelSpeed))
+	g.pos = p
+}
+
+// extrude moves to a point while extruding.
+func (g *gcodeWriter) extrude(p V2) {
+	p = p.Add(g.offset)
+	if g.retract {
+		fmt.Fprintf(g.w, "G1 E%.5f F%.0f\n", g.k.Retraction, 60*g.k.RetractSpeed)
+		g.feed = 60 * g.k.RetractSpeed
+		g.retract = false
+	}
+	d := p.Sub(g.pos).Length()
+	fmt.Fprintf(g.w, "G1 X%.3f Y%.3f E%.5f%s\n", p.X, p.Y, d*g.ePerMM, g.setFeed(g.speed))
+	g.pos = p
+	g.distance += d * g.ePerMM
+}
+
+// loop prints a closed loop starting at the point closest to the current position.
+func (g *gcodeWriter) loop(c V2Set) {
+	start := 0
+	best := math.Inf(1)
+	for i, p := range c {
+		if d := p.Add(g.offset).Sub(g.pos).Length2(); d < best {
+			start, best = i, d
+		}
+	}
+	g.travel(c[start])
+	for i := 1; i <= len(c); i++ {
+		g.extrude(c[(start+i)%len(c)])
+	}
+}
+
+// layer prints a layer.
+func (g *gcodeWriter) layer(i int, l *gcodeLayer) {
+	k := g.k
+	fmt.Fprintf(g.w, ";LAYER:%d\n", i)
+	fmt.Fprintf(g.w, "G0 Z%.3f%s\n", l.z, g.setFeed(k.TravelSpeed))
+	if i == 1 && k.Fan > 0 {
+		fmt.Fprintf(g.w, "M106 S%.0f\n", 255*Clamp(k.Fan, 0, 1))
+	}
+	filament := Pi * 0.25 * k.Filament * k.Filament
+	g.ePerMM = k.ExtrusionWidth * l.height / filament
+	g.speed = k.PrintSpeed
+	if i == 0 {
+		g.speed = k.FirstLayerSpeed
+	}
+	// perimeters, from the inside out for a better outer surface
+	for j := len(l.perimeters) - 1; j >= 0; j-- {
+		g.loop(l.perimeters[j])
+	}
+	// infill
+	for _, line := range l.infill {
+		g.travel(line[0])
+		g.extrude(line[1])
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// EncodeGCode slices an SDF3 and writes G-code for an FDM printer.
+// The bottom of the part is placed on the bed at z = 0.
+func EncodeGCode(w io.Writer, s SDF3, k *GCodeParms) error {
+	sl, err := newGCodeSlicer(s, k)
+	if err != nil {
+		return err
+	}
+	k = sl.k
+	g := &gcodeWriter{
+		w:      bufio.NewWriter(w),
+		k:      k,
+		offset: sl.offset,
+	}
+	// start
+	fmt.Fprintf(g.w, ";generated by sdfx\n;layers: %d\n", len(sl.layers))
+	if k.BedTemp > 0 {
+		fmt.Fprintf(g.w, "M140 S%.0f\n", k.BedTemp)
+	}
+	if k.NozzleTemp > 0 {
+		fmt.Fprintf(g.w, "M104 S%.0f\n", k.NozzleTemp)
+	}
+	fmt.Fprintf(g.w, "G21\nG90\nM83\nG28\n")
+	if k.BedTemp > 0 {
+		fmt.Fprintf(g.w, "M190 S%.0f\n", k.BedTemp)
+	}
+	if k.NozzleTemp > 0 {
+		fmt.Fprintf(g.w, "M109 S%.0f\n", k.NozzleTemp)
+	}
+	fmt.Fprintf(g.w, "G92 E0\nM107\n")
+	// layers
+	for i, l := range sl.toolpaths() {
+		g.layer(i, l)
+	}
+	// end
+	if !g.retract && k.Retraction > 0 {
+		fmt.Fprintf(g.w, "G1 E%.5f F%.0f\n", -k.Retraction, 60*k.RetractSpeed)
+	}
+	fmt.Fprintf(g.w, "G0 Z%.3f F%.0f\n", sl.layers[len(sl.layers)-1]+10, 60*k.TravelSpeed)
+	fmt.Fprintf(g.w, "M107\nM104 S0\nM140 S0\nM84\n")
+	fmt.Fprintf(g.w, ";filament used: %.1fmm\n", g.distance)
+	return g.w.Flush()
+}
+
+// SaveGCode slices an SDF3 and writes G-code to a file.
+func SaveGCode(path string, s SDF3, k *GCodeParms) error {
+	if k != nil {
+		k.logf("slicing %s", path)
+	}
+	return saveFile(path, func(w io.Writer) error {
+		return EncodeGCode(w, s, k)
+	})
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,368 @@
+//-----------------------------------------------------------------------------
+/*
+
+glTF 2.0 Files
+
+Meshes are written as a binary glTF (GLB) file for web and AR viewers. Each
+object is a named node with indexed geometry, vertex normals, a transform and
+a simple PBR material. glTF units are meters and the y axis is up, so the
+nodes are children of a root node that scales the model units and rotates
+the z axis up to the y axis.
+
+See: https://www.khronos.org/registry/glTF/specs/2.0/glTF-2.0.html
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+// glTF constants
+const (
+	gltfFloat        = 5126  // FLOAT component type
+	gltfUnsignedInt  = 5125  // UNSIGNED_INT component type
+	gltfArrayBuffer  = 34962 // ARRAY_BUFFER buffer view target
+	gltfElementArray = 34963 // ELEMENT_ARRAY_BUFFER buffer view target
+	gltfTriangles    = 4     // TRIANGLES primitive mode
+)
+
+// gltfScale maps unit names to a scale in meters.
+var gltfScale = map[string]float64{
+	"":           1e-3,
+	"mm":         1e-3,
+	"millimeter": 1e-3,
+	"micron":     1e-6,
+	"cm":         1e-2,
+	"centimeter": 1e-2,
+	"inch":       0.0254,
+	"foot":       0.3048,
+	"m":          1,
+	"meter":      1,
+}
+
+// glTF JSON document
+
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator,omitempty"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name     string    `json:"name,omitempty"`
+	Mesh     *int      `json:"mesh,omitempty"`
+	Children []int     `json:"children,omitempty"`
+	Matrix   []float64 `json:"matrix,omitempty"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Material   *int           `json:"material,omitempty"`
+	Mode       int            `json:"mode"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name,omitempty"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfPBR struct {
+	BaseColorFactor []float64 `json:"baseColorFactor"`
+	MetallicFactor  float64   `json:"metallicFactor"`
+	RoughnessFactor float64   `json:"roughnessFactor"`
+}
+
+type gltfMaterial struct {
+	Name                 string  `json:"name,omitempty"`
+	PBRMetallicRoughness gltfPBR `json:"pbrMetallicRoughness"`
+	AlphaMode            string  `json:"alphaMode,omitempty"`
+	DoubleSided          bool    `json:"doubleSided,omitempty"`
+}
+
+type gltfBuffer struct {
+	ByteLength int `json:"byteLength"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float64 `json:"min,omitempty"`
+	Max           []float64 `json:"max,omitempty"`
+}
+
+type gltfDocument struct {
+	Asset       gltfAsset        `json:"asset"`
+	Scene       int              `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Materials   []gltfMaterial   `json:"materials,omitempty"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+}
+
+//-----------------------------------------------------------------------------
+
+// gltfObject is an object in a glTF scene.
+type gltfObject struct {
+	name      string
+	mesh      *Mesh3
+	normals   []V3
+	transform M44
+	color     color.Color
+}
+
+// GLTF is a glTF scene object.
+type GLTF struct {
+	name    string
+	scale   float64
+	objects []*gltfObject
+}
+
+// NewGLTF returns an empty glTF scene object.
+// The unit is one of "mm", "inch", "micron", "cm", "m" or "foot".
+func NewGLTF(name, unit string) (*GLTF, error) {
+	scale, ok := gltfScale[unit]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit \"%s\"", unit)
+	}
+	return &GLTF{
+		name:  name,
+		scale: scale,
+	}, nil
+}
+
+// AddObject adds a named mesh to a glTF scene object.
+// The normals are optional (nil == area weighted face normals), use
+// mesh.VertexNormals(s) for smooth normals from the source SDF3.
+// The transform places the mesh in the scene (Identity3d() == none).
+// The color is optional (nil == light gray).
+func (d *GLTF) AddObject(name string, mesh *Mesh3, normals []V3, transform M44, c color.Color) error {
+	if mesh == nil || len(mesh.F) == 0 {
+		return errors.New("empty mesh")
+	}
+	if normals == nil {
+		normals = mesh.VertexNormals(nil)
+	}
+	if len(normals) != len(mesh.V) {
+		return errors.New("wrong number of normals")
+	}
+	d.objects = append(d.objects, &gltfObject{
+		name:      name,
+		mesh:      mesh,
+		normals:   normals,
+		transform: transform,
+		color:     c,
+	})
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// gltfLinear converts an sRGB color component to a linear value.
+func gltfLinear(x uint8) float64 {
+	c := float64(x) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// gltfMaterialFor returns the PBR material for a color.
+func gltfMaterialFor(name string, c color.Color) gltfMaterial {
+	if c == nil {
+		c = color.Gray{0xc0}
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	m := gltfMaterial{
+		Name: name,
+		PBRMetallicRoughness: gltfPBR{
+			BaseColorFactor: []float64{gltfLinear(n.R), gltfLinear(n.G), gltfLinear(n.B), float64(n.A) / 255},
+			MetallicFactor:  0,
+			RoughnessFactor: 0.8,
+		},
+	}
+	if n.A != 0xff {
+		m.AlphaMode = "BLEND"
+	}
+	return m
+}
+
+// gltfYUp rotates -90 degrees about the x axis, so z up becomes y up.
+var gltfYUp = M44{
+	1, 0, 0, 0,
+	0, 0, 1, 0,
+	0, -1, 0, 0,
+	0, 0, 0, 1,
+}
+
+// gltfMatrix returns a transform as a column major glTF matrix.
+func gltfMatrix(m M44) []float64 {
+	return []float64{
+		m.x00, m.x10, m.x20, m.x30,
+		m.x01, m.x11, m.x21, m.x31,
+		m.x02, m.x12, m.x22, m.x32,
+		m.x03, m.x13, m.x23, m.x33,
+	}
+}
+
+// encode returns the JSON document and binary buffer for the scene.
+func (d *GLTF) encode() (*gltfDocument, []byte) {
+	doc := &gltfDocument{
+		Asset:  gltfAsset{Version: "2.0", Generator: "sdfx"},
+		Scenes: []gltfScene{{Nodes: []int{0}}},
+		Nodes: []gltfNode{{
+			Name:   "Scene",
+			Matrix: gltfMatrix(gltfYUp.Mul(Scale3d(V3{d.scale, d.scale, d.scale}))),
+		}},
+	}
+
+	var bin bytes.Buffer
+	put := func(x interface{}) {
+		binary.Write(&bin, binary.LittleEndian, x)
+	}
+	// addView adds a buffer view for the data written by fn.
+	addView := func(target int, fn func()) int {
+		offset := bin.Len()
+		fn()
+		doc.BufferViews = append(doc.BufferViews, gltfBufferView{
+			ByteOffset: offset,
+			ByteLength: bin.Len() - offset,
+			Target:     target,
+		})
+		return len(doc.BufferViews) - 1
+	}
+
+	for i, o := range d.objects {
+		// vertex positions, with bounds
+		lo := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+		hi := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+		pv := addView(gltfArrayBuffer, func() {
+			for _, v := range o.mesh.V {
+				p := [3]float32{float32(v.X), float32(v.Y), float32(v.Z)}
+				for j := range p {
+					lo[j] = math.Min(lo[j], float64(p[j]))
+					hi[j] = math.Max(hi[j], float64(p[j]))
+				}
+				put(p)
+			}
+		})
+		// vertex normals
+		nv := addView(gltfArrayBuffer, func() {
+			for _, n := range o.normals {
+				put([3]float32{float32(n.X), float32(n.Y), float32(n.Z)})
+			}
+		})
+		// triangle indices
+		iv := addView(gltfElementArray, func() {
+			for _, f := range o.mesh.F {
+				put([3]uint32{uint32(f[0]), uint32(f[1]), uint32(f[2])})
+			}
+		})
+
+		a := len(doc.Accessors)
+		doc.Accessors = append(doc.Accessors,
+			gltfAccessor{BufferView: pv, ComponentType: gltfFloat, Count: len(o.mesh.V), Type: "VEC3", Min: lo, Max: hi},
+			gltfAccessor{BufferView: nv, ComponentType: gltfFloat, Count: len(o.normals), Type: "VEC3"},
+			gltfAccessor{BufferView: iv, ComponentType: gltfUnsignedInt, Count: 3 * len(o.mesh.F), Type: "SCALAR"},
+		)
+
+		material := len(doc.Materials)
+		doc.Materials = append(doc.Materials, gltfMaterialFor(o.name, o.color))
+
+		mesh := len(doc.Meshes)
+		doc.Meshes = append(doc.Meshes, gltfMesh{
+			Name: o.name,
+			Primitives: []gltfPrimitive{{
+				Attributes: map[string]int{"POSITION": a, "NORMAL": a + 1},
+				Indices:    a + 2,
+				Material:   &material,
+				Mode:       gltfTriangles,
+			}},
+		})
+
+		node := gltfNode{Name: o.name, Mesh: &mesh}
+		if o.transform != Identity3d() {
+			node.Matrix = gltfMatrix(o.transform)
+		}
+		doc.Nodes = append(doc.Nodes, node)
+		doc.Nodes[0].Children = append(doc.Nodes[0].Children, i+1)
+	}
+
+	doc.Buffers = []gltfBuffer{{ByteLength: bin.Len()}}
+	return doc, bin.Bytes()
+}
+
+// gltfChunk writes a GLB chunk padded to a 4 byte boundary.
+func gltfChunk(w io.Writer, kind string, data []byte, pad byte) error {
+	n := (len(data) + 3) &^ 3
+	hdr := make([]byte, 8)
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(n))
+	copy(hdr[4:], kind)
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(bytes.Repeat([]byte{pad}, n-len(data)))
+	return err
+}
+
+// Encode writes a glTF scene object to a writer in binary (GLB) format.
+func (d *GLTF) Encode(w io.Writer) error {
+	if len(d.objects) == 0 {
+		return errors.New("no objects in glTF scene")
+	}
+	doc, bin := d.encode()
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	// header: magic, version, total length
+	length := 12 + 8 + (len(js)+3)&^3 + 8 + (len(bin)+3)&^3
+	hdr := make([]byte, 12)
+	copy(hdr, "glTF")
+	binary.LittleEndian.PutUint32(hdr[4:], 2)
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(length))
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	if err := gltfChunk(w, "JSON", js, ' '); err != nil {
+		return err
+	}
+	return gltfChunk(w, "BIN\x00", bin, 0)
+}
+
+// Save writes a glTF scene object to a GLB file.
+func (d *GLTF) Save() error {
+	return saveFile(d.name, d.Encode)
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,168 @@
+//-----------------------------------------------------------------------------
+/*
+
+Gradients, Normals and Closest Points
+
+The gradient of a distance field points away from the surface and has unit
+length wherever the field is an exact distance. It is estimated with finite
+differences, either central differences (2n samples) or the vertices of a
+simplex (n+1 samples). Primitives that know their gradient can supply it
+directly by implementing SDF2Gradient or SDF3Gradient.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import "math"
+
+//-----------------------------------------------------------------------------
+
+// SDF2Gradient is an SDF2 with an analytic gradient.
+type SDF2Gradient interface {
+	SDF2
+	Gradient(p V2) V2
+}
+
+// SDF3Gradient is an SDF3 with an analytic gradient.
+type SDF3Gradient interface {
+	SDF3
+	Gradient(p V3) V3
+}
+
+//-----------------------------------------------------------------------------
+
+// GradientMode is the finite difference sampling pattern.
+type GradientMode int
+
+// Gradient sampling modes.
+const (
+	GradientCentral     GradientMode = iota // central differences
+	GradientTetrahedral                     // simplex vertices (fewer evaluations)
+)
+
+// GradientParms are the parameters for gradient estimation.
+type GradientParms struct {
+	Step float64      // finite difference step (0 == 1e-5 of the bounding box size)
+	Mode GradientMode // sampling pattern
+}
+
+// maximum number of closest point iterations
+const closestPointIterations = 32
+
+// gradientStep returns the finite difference step.
+func gradientStep(k *GradientParms, size float64) float64 {
+	if k != nil && k.Step > 0 {
+		return k.Step
+	}
+	return 1e-5 * size
+}
+
+// gradientMode returns the sampling pattern.
+func gradientMode(k *GradientParms) GradientMode {
+	if k == nil {
+		return GradientCentral
+	}
+	return k.Mode
+}
+
+//-----------------------------------------------------------------------------
+
+// the vertices of an equilateral triangle (unit radius)
+var triangle2 = [3]V2{{1, 0}, {-0.5, 0.5 * math.Sqrt(3)}, {-0.5, -0.5 * math.Sqrt(3)}}
+
+// Gradient2 returns the gradient of an SDF2 at p.
+func Gradient2(s SDF2, p V2, k *GradientParms) V2 {
+	if g, ok := s.(SDF2Gradient); ok {
+		return g.Gradient(p)
+	}
+	h := gradientStep(k, s.BoundingBox().Size().MaxComponent())
+	if gradientMode(k) == GradientTetrahedral {
+		var g V2
+		for _, v := range triangle2 {
+			g = g.Add(v.MulScalar(s.Evaluate(p.Add(v.MulScalar(h)))))
+		}
+		return g.MulScalar(2 / (3 * h))
+	}
+	return V2{
+		s.Evaluate(p.Add(V2{h, 0})) - s.Evaluate(p.Sub(V2{h, 0})),
+		s.Evaluate(p.Add(V2{0, h})) - s.Evaluate(p.Sub(V2{0, h})),
+	}.DivScalar(2 * h)
+}
+
+// Normal2 returns the unit normal of an SDF2 at p.
+func Normal2(s SDF2, p V2, k *GradientParms) V2 {
+	return Gradient2(s, p, k).Normalize()
+}
+
+// ClosestPoint2 returns the point on the SDF2 surface closest to p.
+// The point is moved along the gradient (p - d * grad) until the distance
+// is negligible.
+func ClosestPoint2(s SDF2, p V2, k *GradientParms) V2 {
+	tol := 1e-3 * gradientStep(k, s.BoundingBox().Size().MaxComponent())
+	for i := 0; i < closestPointIterations; i++ {
+		d := s.Evaluate(p)
+		if Abs(d) <= tol {
+			break
+		}
+		g := Gradient2(s, p, k)
+		l := g.Length2()
+		if l == 0 {
+			break
+		}
+		p = p.Sub(g.MulScalar(d / l))
+	}
+	return p
+}
+
+//-----------------------------------------------------------------------------
+
+// the vertices of a tetrahedron (inscribed in a cube)
+var tetrahedron3 = [4]V3{{1, -1, -1}, {-1, -1, 1}, {-1, 1, -1}, {1, 1, 1}}
+
+// Gradient3 returns the gradient of an SDF3 at p.
+func Gradient3(s SDF3, p V3, k *GradientParms) V3 {
+	if g, ok := s.(SDF3Gradient); ok {
+		return g.Gradient(p)
+	}
+	h := gradientStep(k, s.BoundingBox().Size().MaxComponent())
+	if gradientMode(k) == GradientTetrahedral {
+		var g V3
+		for _, v := range tetrahedron3 {
+			g = g.Add(v.MulScalar(s.Evaluate(p.Add(v.MulScalar(h)))))
+		}
+		return g.DivScalar(4 * h)
+	}
+	return V3{
+		s.Evaluate(p.Add(V3{h, 0, 0})) - s.Evaluate(p.Sub(V3{h, 0, 0})),
+		s.Evaluate(p.Add(V3{0, h, 0})) - s.Evaluate(p.Sub(V3{0, h, 0})),
+		s.Evaluate(p.Add(V3{0, 0, h})) - s.Evaluate(p.Sub(V3{0, 0, h})),
+	}.DivScalar(2 * h)
+}
+
+// Normal3 returns the unit normal of an SDF3 at p.
+func Normal3(s SDF3, p V3, k *GradientParms) V3 {
+	return Gradient3(s, p, k).Normalize()
+}
+
+// ClosestPoint3 returns the point on the SDF3 surface closest to p.
+// The point is moved along the gradient (p - d * grad) until the distance
+// is negligible.
+func ClosestPoint3(s SDF3, p V3, k *GradientParms) V3 {
+	tol := 1e-3 * gradientStep(k, s.BoundingBox().Size().MaxComponent())
+	for i := 0; i < closestPointIterations; i++ {
+		d := s.Evaluate(p)
+		if Abs(d) <= tol {
+			break
+		}
+		g := Gradient3(s, p, k)
+		l := g.Length2()
+		if l == 0 {
+			break
+		}
+		p = p.Sub(g.MulScalar(d / l))
+	}
+	return p
+}
+
+//-----------------------------------------------------------------------------
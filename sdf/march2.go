@@ -64,9 +64,13 @@ func (l *lineCache) get(x, y int) float64 {
 
 //-----------------------------------------------------------------------------
 
-func marchingSquares(sdf SDF2, box Box2, step float64) []*Line {
+// marchingSquares generates line segments for an SDF2 using a uniform grid.
+// With refine > 0 the edge vertices are found by root finding on the SDF2.
+// The render state (if not nil) controls cancellation and progress.
+func marchingSquares(sdf SDF2, box Box2, step float64, refine int, r *renderState) []*Line {
 
 	var lines []*Line
+	interpolate := msInterpolator(sdf, refine)
 	size := box.Size()
 	base := box.Min
 	steps := size.DivScalar(step).Ceil().ToV2i()
@@ -83,6 +87,9 @@ func marchingSquares(sdf SDF2, box Box2, step float64) []*Line {
 	var p V2
 	p.X = base.X
 	for x := 0; x < nx; x++ {
+		if r.cancelled() {
+			break
+		}
 		// read the x + 1 layer
 		l.evaluate(sdf, x+1)
 		// process all squares in the x and x + 1 layers
@@ -102,10 +109,11 @@ func marchingSquares(sdf SDF2, box Box2, step float64) []*Line {
 				l.get(1, y+1),
 				l.get(0, y+1),
 			}
-			lines = append(lines, msToLines(corners, values, 0)...)
+			lines = append(lines, msToLines(corners, values, 0, interpolate)...)
 			p.Y += dy
 		}
 		p.X += dx
+		r.advance(1 / float64(nx))
 	}
 
 	return lines
@@ -114,7 +122,7 @@ func marchingSquares(sdf SDF2, box Box2, step float64) []*Line {
 //-----------------------------------------------------------------------------
 
 // generate the line segments for a square
-func msToLines(p [4]V2, v [4]float64, x float64) []*Line {
+func msToLines(p [4]V2, v [4]float64, x float64, interpolate msInterpolateFunc) []*Line {
 	// which of the 0..15 patterns do we have?
 	index := 0
 	for i := 0; i < 4; i++ {
@@ -133,7 +141,7 @@ func msToLines(p [4]V2, v [4]float64, x float64) []*Line {
 		if msEdgeTable[index]&bit != 0 {
 			a := msPairTable[i][0]
 			b := msPairTable[i][1]
-			points[i] = msInterpolate(p[a], p[b], v[a], v[b], x)
+			points[i] = interpolate(p[a], p[b], v[a], v[b], x)
 		}
 	}
 	// create the line segments
@@ -153,6 +161,29 @@ func msToLines(p [4]V2, v [4]float64, x float64) []*Line {
 
 //-----------------------------------------------------------------------------
 
+// msInterpolateFunc returns the boundary point on a square edge.
+type msInterpolateFunc func(p1, p2 V2, v1, v2, x float64) V2
+
+// msInterpolator returns the edge interpolation function for an SDF2.
+// With refine > 0 the linear estimate is refined by root finding.
+func msInterpolator(s SDF2, refine int) msInterpolateFunc {
+	if refine <= 0 {
+		return msInterpolate
+	}
+	return func(p1, p2 V2, v1, v2, x float64) V2 {
+		return msRefine(s, p1, p2, v1, v2, x, refine)
+	}
+}
+
+// msRefine finds the point on a square edge where the SDF2 has the value x.
+// See mcRefine.
+func msRefine(s SDF2, p1, p2 V2, v1, v2, x float64, iterations int) V2 {
+	d := p2.Sub(p1)
+	f := func(t float64) float64 { return s.Evaluate(p1.Add(d.MulScalar(t))) - x }
+	t := edgeRoot(f, v1-x, v2-x, tolerance*d.Length(), iterations)
+	return p1.Add(d.MulScalar(t))
+}
+
 func msInterpolate(p1, p2 V2, v1, v2, x float64) V2 {
 
 	closeToV1 := Abs(x-v1) < epsilon
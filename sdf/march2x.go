@@ -14,6 +14,7 @@ package sdf
 import (
 	"math"
 	"sync"
+	"sync/atomic"
 )
 
 //-----------------------------------------------------------------------------
@@ -27,20 +28,24 @@ type square struct {
 // Evaluate the SDF2 via a distance cache to avoid repeated evaluations.
 
 type dcache2 struct {
-	origin     V2              // origin of the overall bounding square
-	resolution float64         // size of smallest quadtree square
-	hdiag      []float64       // lookup table of square half diagonals
-	s          SDF2            // the SDF2 to be rendered
-	cache      map[V2i]float64 // cache of distances
-	lock       sync.RWMutex    // lock the the cache during reads/writes
+	origin     V2                // origin of the overall bounding square
+	resolution float64           // size of smallest quadtree square
+	hdiag      []float64         // lookup table of square half diagonals
+	s          SDF2              // the SDF2 to be rendered
+	interp     msInterpolateFunc // edge interpolation function
+	cache      map[V2i]float64   // cache of distances
+	lock       sync.RWMutex      // lock the the cache during reads/writes
+	lookups    uint64            // number of cache lookups
+	hits       uint64            // number of cache hits
 }
 
-func newDcache2(s SDF2, origin V2, resolution float64, n uint) *dcache2 {
+func newDcache2(s SDF2, origin V2, resolution float64, n uint, refine int) *dcache2 {
 	dc := dcache2{
 		origin:     origin,
 		resolution: resolution,
 		hdiag:      make([]float64, n),
 		s:          s,
+		interp:     msInterpolator(s, refine),
 		cache:      make(map[V2i]float64),
 	}
 	// build a lut for cube half diagonal lengths
@@ -57,6 +62,10 @@ func (dc *dcache2) read(vi V2i) (float64, bool) {
 	dc.lock.RLock()
 	dist, found := dc.cache[vi]
 	dc.lock.RUnlock()
+	atomic.AddUint64(&dc.lookups, 1)
+	if found {
+		atomic.AddUint64(&dc.hits, 1)
+	}
 	return dist, found
 }
 
@@ -90,9 +99,19 @@ func (dc *dcache2) isEmpty(c *square) bool {
 	return Abs(d) >= dc.hdiag[c.n]
 }
 
+// fraction returns the fraction of the quadtree area in a square.
+func (dc *dcache2) fraction(c *square) float64 {
+	return math.Ldexp(1, -2*(len(dc.hdiag)-1-int(c.n)))
+}
+
 // Process a square. Generate line segments, or more squares.
-func (dc *dcache2) processSquare(c *square, output chan<- *Line) {
-	if !dc.isEmpty(c) {
+func (dc *dcache2) processSquare(c *square, output chan<- *Line, r *renderState) {
+	if r.cancelled() {
+		return
+	}
+	if dc.isEmpty(c) {
+		r.advance(dc.fraction(c))
+	} else {
 		if c.n == 1 {
 			// this square is at the required resolution
 			c0, d0 := dc.evaluate(c.v.Add(V2i{0, 0}))
@@ -102,18 +121,19 @@ func (dc *dcache2) processSquare(c *square, output chan<- *Line) {
 			corners := [4]V2{c0, c1, c2, c3}
 			values := [4]float64{d0, d1, d2, d3}
 			// output the line(s) for this square
-			for _, l := range msToLines(corners, values, 0) {
+			for _, l := range msToLines(corners, values, 0, dc.interp) {
 				output <- l
 			}
+			r.advance(dc.fraction(c))
 		} else {
 			// process the sub squares
 			n := c.n - 1
 			s := 1 << n
 			// TODO - turn these into throttled go-routines
-			dc.processSquare(&square{c.v.Add(V2i{0, 0}), n}, output)
-			dc.processSquare(&square{c.v.Add(V2i{s, 0}), n}, output)
-			dc.processSquare(&square{c.v.Add(V2i{s, s}), n}, output)
-			dc.processSquare(&square{c.v.Add(V2i{0, s}), n}, output)
+			dc.processSquare(&square{c.v.Add(V2i{0, 0}), n}, output, r)
+			dc.processSquare(&square{c.v.Add(V2i{s, 0}), n}, output, r)
+			dc.processSquare(&square{c.v.Add(V2i{s, s}), n}, output, r)
+			dc.processSquare(&square{c.v.Add(V2i{0, s}), n}, output, r)
 		}
 	}
 }
@@ -121,7 +141,9 @@ func (dc *dcache2) processSquare(c *square, output chan<- *Line) {
 //-----------------------------------------------------------------------------
 
 // marchingSquaresQuadtree generates line segments for an SDF2 using quadtree subdivision.
-func marchingSquaresQuadtree(s SDF2, resolution float64, output chan<- *Line) {
+// With refine > 0 the edge vertices are found by root finding on the SDF2.
+// The render state (if not nil) controls cancellation and progress.
+func marchingSquaresQuadtree(s SDF2, resolution float64, refine int, r *renderState, output chan<- *Line) {
 	// Scale the bounding box about the center to make sure the boundaries
 	// aren't on the object surface.
 	bb := s.BoundingBox()
@@ -133,9 +155,29 @@ func marchingSquaresQuadtree(s SDF2, resolution float64, output chan<- *Line) {
 	// how many cube levels for the quadtree?
 	levels := uint(math.Ceil(math.Log2(longAxis/resolution))) + 1
 	// create the distance cache
-	dc := newDcache2(s, bb.Min, resolution, levels)
+	dc := newDcache2(s, bb.Min, resolution, levels, refine)
 	// process the quadtree, start at the top level
-	dc.processSquare(&square{V2i{0, 0}, levels - 1}, output)
+	dc.processSquare(&square{V2i{0, 0}, levels - 1}, output, r)
+	r.addCache(dc.lookups, dc.hits)
+}
+
+//-----------------------------------------------------------------------------
+
+// marchingSquaresQuadtreeLines returns the line segments for an SDF2 using quadtree subdivision.
+func marchingSquaresQuadtreeLines(s SDF2, resolution float64, refine int, r *renderState) []*Line {
+	var lines []*Line
+	output := make(chan *Line, 100)
+	done := make(chan bool)
+	go func() {
+		for l := range output {
+			lines = append(lines, l)
+		}
+		done <- true
+	}()
+	marchingSquaresQuadtree(s, resolution, refine, r, output)
+	close(output)
+	<-done
+	return lines
 }
 
 //-----------------------------------------------------------------------------
@@ -11,7 +11,6 @@ Convert an SDF3 to a triangle mesh.
 package sdf
 
 import (
-	"runtime"
 	"sync"
 )
 
@@ -40,25 +39,36 @@ type evalReq struct {
 	wg  *sync.WaitGroup
 }
 
-var evalProcessCh = make(chan evalReq, 100)
+// evalPool is a pool of workers for evaluating an SDF3 in parallel.
+type evalPool struct {
+	ch chan evalReq
+}
 
-func init() {
-	for i := 0; i < runtime.NumCPU(); i++ {
+// newEvalPool starts a pool of evaluation workers.
+func newEvalPool(workers int) *evalPool {
+	p := &evalPool{
+		ch: make(chan evalReq, 100),
+	}
+	for i := 0; i < workers; i++ {
 		go func() {
-			var i int
-			var p V3
-			for r := range evalProcessCh {
-				for i, p = range r.p {
-					r.out[i] = r.fn(p)
+			for r := range p.ch {
+				for i, v := range r.p {
+					r.out[i] = r.fn(v)
 				}
 				r.wg.Done()
 			}
 		}()
 	}
+	return p
+}
+
+// close stops the evaluation workers.
+func (p *evalPool) close() {
+	close(p.ch)
 }
 
 // Evaluate the SDF for a given XY layer
-func (l *layerYZ) Evaluate(sdf SDF3, x int) {
+func (l *layerYZ) Evaluate(pool *evalPool, sdf SDF3, x int) {
 
 	// Swap the layers
 	l.val0, l.val1 = l.val1, l.val0
@@ -96,7 +106,7 @@ func (l *layerYZ) Evaluate(sdf SDF3, x int) {
 			eReq.p = append(eReq.p, p)
 			if len(eReq.p) == batchSize {
 				eReq.wg.Add(1)
-				evalProcessCh <- eReq
+				pool.ch <- eReq
 				eReq.out = eReq.out[batchSize:]   // shift the output slice for processing
 				eReq.p = make([]V3, 0, batchSize) // create a new slice for the next batch
 			}
@@ -109,7 +119,7 @@ func (l *layerYZ) Evaluate(sdf SDF3, x int) {
 	// send any remaining points for processing
 	if len(eReq.p) > 0 {
 		eReq.wg.Add(1)
-		evalProcessCh <- eReq
+		pool.ch <- eReq
 	}
 
 	// Wait for all processing to complete before returning
@@ -126,18 +136,26 @@ func (l *layerYZ) Get(x, y, z int) float64 {
 
 //-----------------------------------------------------------------------------
 
-func marchingCubes(sdf SDF3, box Box3, step float64) []*Triangle3 {
+// marchingCubes generates a triangle mesh for an SDF3 using a uniform grid.
+// With refine > 0 the edge vertices are found by root finding on the SDF3.
+// The render state (if not nil) controls workers, cancellation and progress.
+func marchingCubes(sdf SDF3, box Box3, step float64, refine int, r *renderState) *Mesh3 {
 
-	var triangles []*Triangle3
+	b := newMeshBuilder()
+	interpolate := mcInterpolator(sdf, refine)
 	size := box.Size()
 	base := box.Min
 	steps := size.DivScalar(step).Ceil().ToV3i()
 	inc := size.Div(steps.ToV3())
 
+	// start the evaluation workers
+	pool := newEvalPool(r.numWorkers())
+	defer pool.close()
+
 	// create the SDF layer cache
 	l := newLayerYZ(base, inc, steps)
 	// evaluate the SDF for x = 0
-	l.Evaluate(sdf, 0)
+	l.Evaluate(pool, sdf, 0)
 
 	nx, ny, nz := steps[0], steps[1], steps[2]
 	dx, dy, dz := inc.X, inc.Y, inc.Z
@@ -145,8 +163,11 @@ func marchingCubes(sdf SDF3, box Box3, step float64) []*Triangle3 {
 	var p V3
 	p.X = base.X
 	for x := 0; x < nx; x++ {
+		if r.cancelled() {
+			break
+		}
 		// read the x + 1 layer
-		l.Evaluate(sdf, x+1)
+		l.Evaluate(pool, sdf, x+1)
 		// process all cubes in the x and x + 1 layers
 		p.Y = base.Y
 		for y := 0; y < ny; y++ {
@@ -172,20 +193,40 @@ func marchingCubes(sdf SDF3, box Box3, step float64) []*Triangle3 {
 					l.Get(1, y, z+1),
 					l.Get(1, y+1, z+1),
 					l.Get(0, y+1, z+1)}
-				triangles = append(triangles, mcToTriangles(corners, values, 0)...)
+				keys := mcCornerKeys(V3i{x, y, z}, 1)
+				mcToMesh(b, keys, corners, values, 0, interpolate)
 				p.Z += dz
 			}
 			p.Y += dy
 		}
 		p.X += dx
+		r.advance(1 / float64(nx))
 	}
 
-	return triangles
+	return b.mesh
 }
 
 //-----------------------------------------------------------------------------
 
-func mcToTriangles(p [8]V3, v [8]float64, x float64) []*Triangle3 {
+// mcCornerKeys returns the lattice coordinates of the cube corners given
+// the cube origin and side length.
+func mcCornerKeys(v V3i, s int) [8]V3i {
+	return [8]V3i{
+		v,
+		v.Add(V3i{s, 0, 0}),
+		v.Add(V3i{s, s, 0}),
+		v.Add(V3i{0, s, 0}),
+		v.Add(V3i{0, 0, s}),
+		v.Add(V3i{s, 0, s}),
+		v.Add(V3i{s, s, s}),
+		v.Add(V3i{0, s, s}),
+	}
+}
+
+// mcToMesh adds the triangles for a cube to a mesh.
+// The corner keys are the lattice coordinates of the cube corners. They are
+// used to weld the vertices on edges shared with neighbouring cubes.
+func mcToMesh(b *meshBuilder, k [8]V3i, p [8]V3, v [8]float64, x float64, interpolate mcInterpolateFunc) {
 	// which of the 0..255 patterns do we have?
 	index := 0
 	for i := 0; i < 8; i++ {
@@ -195,36 +236,121 @@ func mcToTriangles(p [8]V3, v [8]float64, x float64) []*Triangle3 {
 	}
 	// do we have any triangles to create?
 	if mcEdgeTable[index] == 0 {
-		return nil
+		return
 	}
-	// work out the interpolated points on the edges
-	var points [12]V3
+	// work out the vertices on the edges
+	var points [12]int
 	for i := 0; i < 12; i++ {
 		bit := 1 << uint(i)
 		if mcEdgeTable[index]&bit != 0 {
-			a := mcPairTable[i][0]
-			b := mcPairTable[i][1]
-			points[i] = mcInterpolate(p[a], p[b], v[a], v[b], x)
+			e0 := mcPairTable[i][0]
+			e1 := mcPairTable[i][1]
+			key := mcEdgeKey(k[e0], k[e1], v[e0], v[e1], x)
+			points[i] = b.vertex(key, func() V3 {
+				return interpolate(p[e0], p[e1], v[e0], v[e1], x)
+			})
 		}
 	}
 	// create the triangles
 	table := mcTriangleTable[index]
 	count := len(table) / 3
-	result := make([]*Triangle3, 0, count)
 	for i := 0; i < count; i++ {
-		t := Triangle3{}
-		t.V[2] = points[table[i*3+0]]
-		t.V[1] = points[table[i*3+1]]
-		t.V[0] = points[table[i*3+2]]
-		if !t.Degenerate(0) {
-			result = append(result, &t)
-		}
+		b.mesh.AddFace(points[table[i*3+2]], points[table[i*3+1]], points[table[i*3+0]])
 	}
-	return result
+}
+
+// mcEdgeKey returns the vertex key for an interpolated point on a cube edge.
+// Points at the corners are keyed on the corner so they are welded with the
+// points on other edges that meet at that corner.
+func mcEdgeKey(k1, k2 V3i, v1, v2, x float64) V3i {
+	closeToV1 := Abs(x-v1) < epsilon
+	closeToV2 := Abs(x-v2) < epsilon
+	if closeToV1 && !closeToV2 {
+		return k1.Add(k1)
+	}
+	if closeToV2 && !closeToV1 {
+		return k2.Add(k2)
+	}
+	return k1.Add(k2)
 }
 
 //-----------------------------------------------------------------------------
 
+// mcInterpolateFunc returns the surface point on a cube edge.
+type mcInterpolateFunc func(p1, p2 V3, v1, v2, x float64) V3
+
+// mcInterpolator returns the edge interpolation function for an SDF3.
+// With refine > 0 the linear estimate is refined by root finding.
+func mcInterpolator(s SDF3, refine int) mcInterpolateFunc {
+	if refine <= 0 {
+		return mcInterpolate
+	}
+	return func(p1, p2 V3, v1, v2, x float64) V3 {
+		return mcRefine(s, p1, p2, v1, v2, x, refine)
+	}
+}
+
+// mcRefine finds the point on a cube edge where the SDF3 has the value x.
+// Linear interpolation puts the point off the surface when the field is not
+// a true distance (e.g. scaling, smooth blends, screws), so the root is found
+// with the SDF3 itself.
+func mcRefine(s SDF3, p1, p2 V3, v1, v2, x float64, iterations int) V3 {
+	d := p2.Sub(p1)
+	f := func(t float64) float64 { return s.Evaluate(p1.Add(d.MulScalar(t))) - x }
+	t := edgeRoot(f, v1-x, v2-x, tolerance*d.Length(), iterations)
+	return p1.Add(d.MulScalar(t))
+}
+
+// edgeRoot finds the root of f(t) on an edge (0 <= t <= 1), given the end
+// values v1 = f(0) and v2 = f(1). This iterates false position with the
+// Illinois modification until |f(t)| < limit.
+func edgeRoot(f func(t float64) float64, v1, v2, limit float64, iterations int) float64 {
+	t1, t2 := 0.0, 1.0
+	t := edgeInterpolate(t1, t2, v1, v2)
+	if Abs(v1) < epsilon || Abs(v2) < epsilon {
+		return t
+	}
+	side := 0
+	for i := 0; i < iterations; i++ {
+		vt := f(t)
+		if Abs(vt) < limit {
+			break
+		}
+		if (vt < 0) == (v1 < 0) {
+			t1, v1 = t, vt
+			if side == -1 {
+				v2 *= 0.5
+			}
+			side = -1
+		} else {
+			t2, v2 = t, vt
+			if side == 1 {
+				v1 *= 0.5
+			}
+			side = 1
+		}
+		t = edgeInterpolate(t1, t2, v1, v2)
+	}
+	return t
+}
+
+// edgeInterpolate returns the linear estimate of the root between t1 and t2.
+func edgeInterpolate(t1, t2, v1, v2 float64) float64 {
+	closeToV1 := Abs(v1) < epsilon
+	closeToV2 := Abs(v2) < epsilon
+	if closeToV1 && !closeToV2 {
+		return t1
+	}
+	if closeToV2 && !closeToV1 {
+		return t2
+	}
+	if closeToV1 && closeToV2 {
+		// pick the half way point
+		return 0.5 * (t1 + t2)
+	}
+	return t1 - v1*(t2-t1)/(v2-v1)
+}
+
 func mcInterpolate(p1, p2 V3, v1, v2, x float64) V3 {
 
 	closeToV1 := Abs(x-v1) < epsilon
@@ -14,6 +14,7 @@ package sdf
 import (
 	"math"
 	"sync"
+	"sync/atomic"
 )
 
 //-----------------------------------------------------------------------------
@@ -29,15 +30,18 @@ type cube struct {
 // is about 2x a non-cached evaluation.
 
 type dcache3 struct {
-	origin     V3              // origin of the overall bounding cube
-	resolution float64         // size of smallest octree cube
-	hdiag      []float64       // lookup table of cube half diagonals
-	s          SDF3            // the SDF3 to be rendered
-	cache      map[V3i]float64 // cache of distances
-	lock       sync.RWMutex    // lock the the cache during reads/writes
+	origin     V3                // origin of the overall bounding cube
+	resolution float64           // size of smallest octree cube
+	hdiag      []float64         // lookup table of cube half diagonals
+	s          SDF3              // the SDF3 to be rendered
+	interp     mcInterpolateFunc // edge interpolation function
+	cache      map[V3i]float64   // cache of distances
+	lock       sync.RWMutex      // lock the the cache during reads/writes
+	lookups    uint64            // number of cache lookups
+	hits       uint64            // number of cache hits
 }
 
-func newDcache3(s SDF3, origin V3, resolution float64, n uint) *dcache3 {
+func newDcache3(s SDF3, origin V3, resolution float64, n uint, refine int) *dcache3 {
 	// TODO heuristic for initial cache size. Maybe k * (1 << n)^3
 	// Avoiding any resizing of the map seems to be worth 2-5% of speedup.
 	dc := dcache3{
@@ -45,6 +49,7 @@ func newDcache3(s SDF3, origin V3, resolution float64, n uint) *dcache3 {
 		resolution: resolution,
 		hdiag:      make([]float64, n),
 		s:          s,
+		interp:     mcInterpolator(s, refine),
 		cache:      make(map[V3i]float64),
 	}
 	// build a lut for cube half diagonal lengths
@@ -61,6 +66,10 @@ func (dc *dcache3) read(vi V3i) (float64, bool) {
 	dc.lock.RLock()
 	dist, found := dc.cache[vi]
 	dc.lock.RUnlock()
+	atomic.AddUint64(&dc.lookups, 1)
+	if found {
+		atomic.AddUint64(&dc.hits, 1)
+	}
 	return dist, found
 }
 
@@ -94,38 +103,43 @@ func (dc *dcache3) isEmpty(c *cube) bool {
 	return Abs(d) >= dc.hdiag[c.n]
 }
 
+// fraction returns the fraction of the octree volume in a cube.
+func (dc *dcache3) fraction(c *cube) float64 {
+	return math.Ldexp(1, -3*(len(dc.hdiag)-1-int(c.n)))
+}
+
 // Process a cube. Generate triangles, or more cubes.
-func (dc *dcache3) processCube(c *cube, output chan<- *Triangle3) {
-	if !dc.isEmpty(c) {
+func (dc *dcache3) processCube(c *cube, b *meshBuilder, r *renderState) {
+	if r.cancelled() {
+		return
+	}
+	if dc.isEmpty(c) {
+		r.advance(dc.fraction(c))
+	} else {
 		if c.n == 1 {
 			// this cube is at the required resolution
-			c0, d0 := dc.evaluate(c.v.Add(V3i{0, 0, 0}))
-			c1, d1 := dc.evaluate(c.v.Add(V3i{2, 0, 0}))
-			c2, d2 := dc.evaluate(c.v.Add(V3i{2, 2, 0}))
-			c3, d3 := dc.evaluate(c.v.Add(V3i{0, 2, 0}))
-			c4, d4 := dc.evaluate(c.v.Add(V3i{0, 0, 2}))
-			c5, d5 := dc.evaluate(c.v.Add(V3i{2, 0, 2}))
-			c6, d6 := dc.evaluate(c.v.Add(V3i{2, 2, 2}))
-			c7, d7 := dc.evaluate(c.v.Add(V3i{0, 2, 2}))
-			corners := [8]V3{c0, c1, c2, c3, c4, c5, c6, c7}
-			values := [8]float64{d0, d1, d2, d3, d4, d5, d6, d7}
-			// output the triangle(s) for this cube
-			for _, t := range mcToTriangles(corners, values, 0) {
-				output <- t
+			keys := mcCornerKeys(c.v, 2)
+			var corners [8]V3
+			var values [8]float64
+			for i, k := range keys {
+				corners[i], values[i] = dc.evaluate(k)
 			}
+			// add the triangle(s) for this cube
+			mcToMesh(b, keys, corners, values, 0, dc.interp)
+			r.advance(dc.fraction(c))
 		} else {
 			// process the sub cubes
 			n := c.n - 1
 			s := 1 << n
 			// TODO - turn these into throttled go-routines
-			dc.processCube(&cube{c.v.Add(V3i{0, 0, 0}), n}, output)
-			dc.processCube(&cube{c.v.Add(V3i{s, 0, 0}), n}, output)
-			dc.processCube(&cube{c.v.Add(V3i{s, s, 0}), n}, output)
-			dc.processCube(&cube{c.v.Add(V3i{0, s, 0}), n}, output)
-			dc.processCube(&cube{c.v.Add(V3i{0, 0, s}), n}, output)
-			dc.processCube(&cube{c.v.Add(V3i{s, 0, s}), n}, output)
-			dc.processCube(&cube{c.v.Add(V3i{s, s, s}), n}, output)
-			dc.processCube(&cube{c.v.Add(V3i{0, s, s}), n}, output)
+			dc.processCube(&cube{c.v.Add(V3i{0, 0, 0}), n}, b, r)
+			dc.processCube(&cube{c.v.Add(V3i{s, 0, 0}), n}, b, r)
+			dc.processCube(&cube{c.v.Add(V3i{s, s, 0}), n}, b, r)
+			dc.processCube(&cube{c.v.Add(V3i{0, s, 0}), n}, b, r)
+			dc.processCube(&cube{c.v.Add(V3i{0, 0, s}), n}, b, r)
+			dc.processCube(&cube{c.v.Add(V3i{s, 0, s}), n}, b, r)
+			dc.processCube(&cube{c.v.Add(V3i{s, s, s}), n}, b, r)
+			dc.processCube(&cube{c.v.Add(V3i{0, s, s}), n}, b, r)
 		}
 	}
 }
@@ -133,7 +147,9 @@ func (dc *dcache3) processCube(c *cube, output chan<- *Triangle3) {
 //-----------------------------------------------------------------------------
 
 // marchingCubesOctree generates a triangle mesh for an SDF3 using octree subdivision.
-func marchingCubesOctree(s SDF3, resolution float64, output chan<- *Triangle3) {
+// With refine > 0 the edge vertices are found by root finding on the SDF3.
+// The render state (if not nil) controls cancellation and progress.
+func marchingCubesOctree(s SDF3, resolution float64, refine int, r *renderState) *Mesh3 {
 	// Scale the bounding box about the center to make sure the boundaries
 	// aren't on the object surface.
 	bb := s.BoundingBox()
@@ -145,9 +161,12 @@ func marchingCubesOctree(s SDF3, resolution float64, output chan<- *Triangle3) {
 	// how many cube levels for the octree?
 	levels := uint(math.Ceil(math.Log2(longAxis/resolution))) + 1
 	// create the distance cache
-	dc := newDcache3(s, bb.Min, resolution, levels)
+	dc := newDcache3(s, bb.Min, resolution, levels, refine)
 	// process the octree, start at the top level
-	dc.processCube(&cube{V3i{0, 0, 0}, levels - 1}, output)
+	b := newMeshBuilder()
+	dc.processCube(&cube{V3i{0, 0, 0}, levels - 1}, b, r)
+	r.addCache(dc.lookups, dc.hits)
+	return b.mesh
 }
 
 //-----------------------------------------------------------------------------
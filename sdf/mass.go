@@ -0,0 +1,275 @@
+//-----------------------------------------------------------------------------
+/*
+
+Mass Properties
+
+Integrates over the interior of an SDF3 to find its volume, surface area,
+center of mass and inertia tensor (and the area, perimeter and centroid of
+an SDF2).
+
+Space is subdivided as an octree (quadtree in 2d). Cubes that are entirely
+inside the object are integrated exactly and cubes that are entirely outside
+are skipped. The smallest cubes near the surface are sampled at their
+centers: the distance to the surface gives the fraction of the cube that is
+inside, and a weight that falls off linearly over one cube size gives the
+surface area within the cube.
+
+The smallest cubes on the surface may be anywhere from empty to full, so
+their total volume bounds the error in the volume.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import "math"
+
+//-----------------------------------------------------------------------------
+
+// MassParms are the parameters for mass properties.
+type MassParms struct {
+	Resolution float64 // size of the smallest cube (0 == 1/200 of the bounding box size)
+	Density    float64 // mass per unit volume (0 == 1)
+}
+
+// resolution returns the size of the smallest cube.
+func (k *MassParms) resolution(size float64) float64 {
+	if k == nil || k.Resolution <= 0 {
+		return size / 200
+	}
+	return k.Resolution
+}
+
+// density returns the mass per unit volume.
+func (k *MassParms) density() float64 {
+	if k == nil || k.Density <= 0 {
+		return 1
+	}
+	return k.Density
+}
+
+// massLevels returns the number of cube levels needed to cover a size.
+func massLevels(size, resolution float64) uint {
+	n := uint(0)
+	for float64(int64(1)<<n)*resolution < size {
+		n++
+	}
+	return n
+}
+
+// massFraction returns the fraction of a cube (or square) of size h that is
+// inside the surface, given the distance from its center.
+func massFraction(d, h float64) float64 {
+	return Clamp(0.5-d/h, 0, 1)
+}
+
+// massDelta returns the surface weight of a cube (or square) of size h,
+// given the distance from its center. The weights of the cubes along any
+// line through the surface sum to about one.
+func massDelta(d, h float64) float64 {
+	return math.Max(1-Abs(d)/h, 0)
+}
+
+//-----------------------------------------------------------------------------
+
+// MassProperties3 are the mass properties of an SDF3.
+type MassProperties3 struct {
+	Volume      float64 // volume
+	VolumeError float64 // bound on the volume error
+	Area        float64 // surface area
+	Mass        float64 // mass (volume * density)
+	Centroid    V3      // center of mass
+	Inertia     M33     // inertia tensor about the center of mass
+}
+
+// mass3 integrates over an SDF3.
+type mass3 struct {
+	s      SDF3
+	origin V3      // origin of the overall bounding cube
+	center V3      // moments are taken about this point
+	res    float64 // size of the smallest cube
+	hdiag  []float64
+	// integrals of 1, x, y, z, x*x, y*y, z*z, x*y, y*z, z*x about the center
+	v, x, y, z, xx, yy, zz, xy, yz, zx float64
+	area                               float64 // surface area
+	err                                float64 // volume error bound
+}
+
+// add adds a box (center p, size h, fraction f inside) to the integrals.
+func (m *mass3) add(p V3, h, f float64) {
+	p = p.Sub(m.center)
+	v := f * h * h * h
+	q := h * h / 12 // second moment of a cube about its center (per unit volume)
+	m.v += v
+	m.x += v * p.X
+	m.y += v * p.Y
+	m.z += v * p.Z
+	m.xx += v * (p.X*p.X + q)
+	m.yy += v * (p.Y*p.Y + q)
+	m.zz += v * (p.Z*p.Z + q)
+	m.xy += v * p.X * p.Y
+	m.yz += v * p.Y * p.Z
+	m.zx += v * p.Z * p.X
+}
+
+// cube integrates over a cube of the octree.
+func (m *mass3) cube(c cube) {
+	h := float64(int64(1)<<c.n) * m.res
+	p := m.origin.Add(c.v.ToV3().MulScalar(m.res)).AddScalar(0.5 * h)
+	d := m.s.Evaluate(p)
+	if Abs(d) >= m.hdiag[c.n]+m.res {
+		// the cube is entirely inside or outside
+		if d < 0 {
+			m.add(p, h, 1)
+		}
+		return
+	}
+	if c.n == 0 {
+		// correct the distance for fields that are only a bound
+		g := Gradient3(m.s, p, &GradientParms{Step: 0.1 * h}).Length()
+		if g > 0 {
+			d /= g
+		}
+		f := massFraction(d, h)
+		m.add(p, h, f)
+		m.err += math.Max(f, 1-f) * h * h * h
+		m.area += h * h * massDelta(d, h)
+		return
+	}
+	n := c.n - 1
+	s := 1 << n
+	for i := 0; i < 8; i++ {
+		m.cube(cube{c.v.Add(V3i{s * (i & 1), s * ((i >> 1) & 1), s * (i >> 2)}), n})
+	}
+}
+
+// Mass3 returns the mass properties of an SDF3.
+func Mass3(s SDF3, k *MassParms) *MassProperties3 {
+	bb := s.BoundingBox()
+	res := k.resolution(bb.Size().MaxComponent())
+	// sample the surface cubes just outside the bounding box
+	levels := massLevels(bb.Size().MaxComponent()+4*res, res)
+	m := &mass3{
+		s:      s,
+		origin: bb.Min.SubScalar(2 * res),
+		center: bb.Center(),
+		res:    res,
+		hdiag:  make([]float64, levels+1),
+	}
+	for i := range m.hdiag {
+		m.hdiag[i] = 0.5 * math.Sqrt(3) * float64(int64(1)<<uint(i)) * res
+	}
+	m.cube(cube{V3i{0, 0, 0}, levels})
+
+	density := k.density()
+	mp := &MassProperties3{
+		Volume:      m.v,
+		VolumeError: m.err,
+		Area:        m.area,
+		Mass:        m.v * density,
+		Centroid:    m.center,
+	}
+	if m.v == 0 {
+		return mp
+	}
+	// moments about the centroid
+	c := V3{m.x, m.y, m.z}.DivScalar(m.v)
+	mp.Centroid = m.center.Add(c)
+	xx := density * (m.xx - m.v*c.X*c.X)
+	yy := density * (m.yy - m.v*c.Y*c.Y)
+	zz := density * (m.zz - m.v*c.Z*c.Z)
+	xy := density * (m.xy - m.v*c.X*c.Y)
+	yz := density * (m.yz - m.v*c.Y*c.Z)
+	zx := density * (m.zx - m.v*c.Z*c.X)
+	mp.Inertia = M33{
+		yy + zz, -xy, -zx,
+		-xy, zz + xx, -yz,
+		-zx, -yz, xx + yy,
+	}
+	return mp
+}
+
+//-----------------------------------------------------------------------------
+
+// MassProperties2 are the mass properties of an SDF2.
+type MassProperties2 struct {
+	Area      float64 // area
+	AreaError float64 // bound on the area error
+	Perimeter float64 // perimeter
+	Centroid  V2      // centroid
+}
+
+// mass2 integrates over an SDF2.
+type mass2 struct {
+	s         SDF2
+	origin    V2      // origin of the overall bounding square
+	res       float64 // size of the smallest square
+	hdiag     []float64
+	a         float64 // area
+	m         V2      // first moment of area
+	perimeter float64 // perimeter
+	err       float64 // area error bound
+}
+
+// square integrates over a square of the quadtree.
+func (m *mass2) square(v V2i, n uint) {
+	h := float64(int64(1)<<n) * m.res
+	p := m.origin.Add(v.ToV2().MulScalar(m.res)).AddScalar(0.5 * h)
+	d := m.s.Evaluate(p)
+	if Abs(d) >= m.hdiag[n]+m.res {
+		// the square is entirely inside or outside
+		if d < 0 {
+			m.a += h * h
+			m.m = m.m.Add(p.MulScalar(h * h))
+		}
+		return
+	}
+	if n == 0 {
+		// correct the distance for fields that are only a bound
+		g := Gradient2(m.s, p, &GradientParms{Step: 0.1 * h}).Length()
+		if g > 0 {
+			d /= g
+		}
+		f := massFraction(d, h)
+		m.a += f * h * h
+		m.m = m.m.Add(p.MulScalar(f * h * h))
+		m.err += math.Max(f, 1-f) * h * h
+		m.perimeter += h * massDelta(d, h)
+		return
+	}
+	n--
+	s := 1 << n
+	for i := 0; i < 4; i++ {
+		m.square(v.Add(V2i{s * (i & 1), s * (i >> 1)}), n)
+	}
+}
+
+// Mass2 returns the area, perimeter and centroid of an SDF2.
+func Mass2(s SDF2, k *MassParms) *MassProperties2 {
+	bb := s.BoundingBox()
+	res := k.resolution(bb.Size().MaxComponent())
+	// sample the surface squares just outside the bounding box
+	levels := massLevels(bb.Size().MaxComponent()+4*res, res)
+	m := &mass2{
+		s:      s,
+		origin: bb.Min.SubScalar(2 * res),
+		res:    res,
+		hdiag:  make([]float64, levels+1),
+	}
+	for i := range m.hdiag {
+		m.hdiag[i] = 0.5 * math.Sqrt2 * float64(int64(1)<<uint(i)) * res
+	}
+	m.square(V2i{0, 0}, levels)
+	mp := &MassProperties2{
+		Area:      m.a,
+		AreaError: m.err,
+		Perimeter: m.perimeter,
+		Centroid:  bb.Center(),
+	}
+	if m.a > 0 {
+		mp.Centroid = m.m.DivScalar(m.a)
+	}
+	return mp
+}
+
+//-----------------------------------------------------------------------------
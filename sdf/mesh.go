@@ -0,0 +1,200 @@
+//-----------------------------------------------------------------------------
+/*
+
+Indexed Triangle Meshes
+
+A mesh is a shared array of vertices and a set of triangular faces that
+index into it. The renderers build meshes by keying vertices on the edges
+of the sampling lattice, so the vertices of adjacent cells are welded
+exactly and the output mesh is closed.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+// Mesh3 is an indexed 3d triangle mesh.
+type Mesh3 struct {
+	V []V3     // vertices
+	F [][3]int // faces, indices into V with counter-clockwise winding viewed from outside
+}
+
+// NewMesh3 returns an indexed mesh built from a set of triangles.
+// Vertices within tolerance of each other are welded together.
+func NewMesh3(triangles []*Triangle3, tolerance float64) *Mesh3 {
+	m := &Mesh3{}
+	w := newWelder3(m, tolerance)
+	for _, t := range triangles {
+		m.AddFace(w.vertex(t.V[0]), w.vertex(t.V[1]), w.vertex(t.V[2]))
+	}
+	return m
+}
+
+// AddVertex adds a vertex to the mesh and returns its index.
+func (m *Mesh3) AddVertex(v V3) int {
+	m.V = append(m.V, v)
+	return len(m.V) - 1
+}
+
+// AddFace adds a triangular face to the mesh.
+// Degenerate faces (repeated vertex indices) are not added.
+func (m *Mesh3) AddFace(a, b, c int) {
+	if a == b || b == c || c == a {
+		return
+	}
+	m.F = append(m.F, [3]int{a, b, c})
+}
+
+// Triangle returns the i-th face of the mesh as a triangle.
+func (m *Mesh3) Triangle(i int) *Triangle3 {
+	f := m.F[i]
+	return NewTriangle3(m.V[f[0]], m.V[f[1]], m.V[f[2]])
+}
+
+// Triangles returns the mesh as a set of triangles.
+func (m *Mesh3) Triangles() []*Triangle3 {
+	t := make([]*Triangle3, len(m.F))
+	for i := range m.F {
+		t[i] = m.Triangle(i)
+	}
+	return t
+}
+
+// BoundingBox returns the bounding box of the mesh vertices.
+func (m *Mesh3) BoundingBox() Box3 {
+	if len(m.V) == 0 {
+		return Box3{}
+	}
+	return Box3{V3Set(m.V).Min(), V3Set(m.V).Max()}
+}
+
+// Closed returns true if the mesh is closed and manifold.
+// Every edge is shared by exactly two faces with opposite winding.
+func (m *Mesh3) Closed() bool {
+	edges := make(map[[2]int]int)
+	for _, f := range m.F {
+		for i := 0; i < 3; i++ {
+			edges[[2]int{f[i], f[(i+1)%3]}]++
+		}
+	}
+	for e, n := range edges {
+		if n != 1 || edges[[2]int{e[1], e[0]}] != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// VertexNormals returns the unit normals at the mesh vertices.
+// If the source SDF3 is given the normals are its gradient, otherwise they
+// are the area weighted face normals.
+func (m *Mesh3) VertexNormals(s SDF3) []V3 {
+	n := make([]V3, len(m.V))
+	if s != nil {
+		k := &GradientParms{Step: 1e-5 * m.BoundingBox().Size().MaxComponent()}
+		for i, p := range m.V {
+			n[i] = Normal3(s, p, k)
+		}
+		return n
+	}
+	for _, f := range m.F {
+		a := m.V[f[1]].Sub(m.V[f[0]]).Cross(m.V[f[2]].Sub(m.V[f[0]]))
+		for _, i := range f {
+			n[i] = n[i].Add(a)
+		}
+	}
+	for i := range n {
+		if n[i].Length2() > 0 {
+			n[i] = n[i].Normalize()
+		}
+	}
+	return n
+}
+
+//-----------------------------------------------------------------------------
+// Build a mesh from vertices keyed on a sampling lattice.
+
+// meshBuilder adds vertices to a mesh, welding vertices with the same key.
+type meshBuilder struct {
+	mesh  *Mesh3
+	index map[V3i]int
+}
+
+func newMeshBuilder() *meshBuilder {
+	return &meshBuilder{
+		mesh:  &Mesh3{},
+		index: make(map[V3i]int),
+	}
+}
+
+// vertex returns the index of the vertex with a given key.
+// The vertex position is found with fn the first time the key is seen.
+func (b *meshBuilder) vertex(key V3i, fn func() V3) int {
+	if i, found := b.index[key]; found {
+		return i
+	}
+	i := b.mesh.AddVertex(fn())
+	b.index[key] = i
+	return i
+}
+
+//-----------------------------------------------------------------------------
+// Weld arbitrary vertices to within a tolerance.
+
+// welder3 merges vertices that are within a tolerance of each other.
+type welder3 struct {
+	mesh      *Mesh3
+	tolerance float64
+	grid      map[V3i][]int // spatial hash of vertex indices
+}
+
+func newWelder3(m *Mesh3, tolerance float64) *welder3 {
+	return &welder3{
+		mesh:      m,
+		tolerance: tolerance,
+		grid:      make(map[V3i][]int),
+	}
+}
+
+// cell returns the spatial hash cell for a vertex.
+func (w *welder3) cell(v V3) V3i {
+	if w.tolerance == 0 {
+		// exact matching, hash on the bit patterns
+		return V3i{int(math.Float64bits(v.X)), int(math.Float64bits(v.Y)), int(math.Float64bits(v.Z))}
+	}
+	return v.DivScalar(w.tolerance).Floor().ToV3i()
+}
+
+// vertex returns the index of a vertex, adding it to the mesh if it is new.
+func (w *welder3) vertex(v V3) int {
+	c := w.cell(v)
+	if w.tolerance == 0 {
+		if idx, found := w.grid[c]; found {
+			return idx[0]
+		}
+	} else {
+		// search the neighbouring cells
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for dz := -1; dz <= 1; dz++ {
+					for _, i := range w.grid[c.Add(V3i{dx, dy, dz})] {
+						if w.mesh.V[i].Equals(v, w.tolerance) {
+							return i
+						}
+					}
+				}
+			}
+		}
+	}
+	i := w.mesh.AddVertex(v)
+	w.grid[c] = append(w.grid[c], i)
+	return i
+}
+
+//-----------------------------------------------------------------------------
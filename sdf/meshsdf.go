@@ -0,0 +1,261 @@
+//-----------------------------------------------------------------------------
+/*
+
+Mesh SDF3
+
+An SDF3 for a triangle mesh (e.g. an imported STL file).
+
+The distance is the distance to the closest triangle. The triangles are kept
+in a bounding volume hierarchy so the closest triangle search only visits the
+nodes near the query point.
+
+The sign comes from the generalized winding number of the mesh. This is 1 for
+points inside a closed mesh and 0 for points outside, and is well behaved for
+meshes with small holes or overlapping parts. Distant nodes of the hierarchy
+are approximated with a dipole so the cost is logarithmic in the mesh size.
+
+See: "Robust Inside-Outside Segmentation using Generalized Winding Numbers", Jacobson et al.
+See: "Fast Winding Numbers for Soups and Clouds", Barill et al.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+//-----------------------------------------------------------------------------
+
+// meshLeafSize is the maximum number of triangles in a leaf node.
+const meshLeafSize = 4
+
+// meshBeta is the distance (relative to the node radius) beyond which the
+// winding number of a node is approximated.
+const meshBeta = 2.0
+
+// meshNode is a node of the bounding volume hierarchy.
+type meshNode struct {
+	bb          Box3    // bounding box of the node triangles
+	left, right int     // child nodes (0 == leaf node)
+	start, end  int     // triangle range for a leaf node
+	center      V3      // area weighted centroid of the node triangles
+	area        V3      // sum of the area vectors of the node triangles
+	radius      float64 // maximum distance from the center to the bounding box
+}
+
+// MeshSDF3 is an SDF3 for a triangle mesh.
+type MeshSDF3 struct {
+	t     []Triangle3 // triangles in hierarchy order
+	nodes []meshNode  // bounding volume hierarchy, nodes[0] is the root
+	bb    Box3
+}
+
+// Mesh3D returns an SDF3 for a triangle mesh.
+// The mesh should be closed with the triangles wound counter-clockwise when
+// viewed from outside.
+func Mesh3D(triangles []*Triangle3) (SDF3, error) {
+	s := MeshSDF3{}
+	for _, t := range triangles {
+		if !t.Degenerate(0) {
+			s.t = append(s.t, *t)
+		}
+	}
+	if len(s.t) == 0 {
+		return nil, errors.New("no triangles in mesh")
+	}
+	s.nodes = make([]meshNode, 1, 2*len(s.t)/meshLeafSize+1)
+	s.build(0, 0, len(s.t))
+	s.bb = s.nodes[0].bb
+	return &s, nil
+}
+
+// build builds the node of the hierarchy for a range of triangles.
+func (s *MeshSDF3) build(i, start, end int) {
+	t := s.t[start:end]
+	n := &s.nodes[i]
+	n.start, n.end = start, end
+	n.bb = Box3{t[0].V[0], t[0].V[0]}
+	var area float64
+	for j := range t {
+		for _, v := range t[j].V {
+			n.bb = Box3{n.bb.Min.Min(v), n.bb.Max.Max(v)}
+		}
+		a := t[j].V[1].Sub(t[j].V[0]).Cross(t[j].V[2].Sub(t[j].V[0])).MulScalar(0.5)
+		c := t[j].V[0].Add(t[j].V[1]).Add(t[j].V[2]).DivScalar(3)
+		n.area = n.area.Add(a)
+		n.center = n.center.Add(c.MulScalar(a.Length()))
+		area += a.Length()
+	}
+	if area > 0 {
+		n.center = n.center.DivScalar(area)
+	} else {
+		n.center = n.bb.Center()
+	}
+	for _, v := range n.bb.Vertices() {
+		n.radius = Max(n.radius, v.Sub(n.center).Length())
+	}
+	if len(t) <= meshLeafSize {
+		return
+	}
+	// split the triangles at the median of the longest axis
+	size := n.bb.Size()
+	key := func(v V3) float64 { return v.X }
+	if size.Y > size.X && size.Y >= size.Z {
+		key = func(v V3) float64 { return v.Y }
+	} else if size.Z > size.X {
+		key = func(v V3) float64 { return v.Z }
+	}
+	sort.Slice(t, func(a, b int) bool {
+		ca := t[a].V[0].Add(t[a].V[1]).Add(t[a].V[2])
+		cb := t[b].V[0].Add(t[b].V[1]).Add(t[b].V[2])
+		return key(ca) < key(cb)
+	})
+	mid := start + len(t)/2
+	left := len(s.nodes)
+	s.nodes = append(s.nodes, meshNode{}, meshNode{})
+	s.nodes[i].left, s.nodes[i].right = left, left+1
+	s.build(left, start, mid)
+	s.build(left+1, mid, end)
+}
+
+//-----------------------------------------------------------------------------
+
+// meshBoxDist2 returns the minimum distance squared from a point to a box.
+func meshBoxDist2(b Box3, p V3) float64 {
+	d := b.Min.Sub(p).Max(p.Sub(b.Max)).Max(V3{})
+	return d.Length2()
+}
+
+// meshClosestPoint returns the closest point on a triangle to a point.
+// See: "Real-Time Collision Detection", Ericson, 5.1.5
+func meshClosestPoint(t *Triangle3, p V3) V3 {
+	a, b, c := t.V[0], t.V[1], t.V[2]
+	ab := b.Sub(a)
+	ac := c.Sub(a)
+	ap := p.Sub(a)
+	d1 := ab.Dot(ap)
+	d2 := ac.Dot(ap)
+	if d1 <= 0 && d2 <= 0 {
+		return a
+	}
+	bp := p.Sub(b)
+	d3 := ab.Dot(bp)
+	d4 := ac.Dot(bp)
+	if d3 >= 0 && d4 <= d3 {
+		return b
+	}
+	vc := d1*d4 - d3*d2
+	if vc <= 0 && d1 >= 0 && d3 <= 0 {
+		return a.Add(ab.MulScalar(d1 / (d1 - d3)))
+	}
+	cp := p.Sub(c)
+	d5 := ab.Dot(cp)
+	d6 := ac.Dot(cp)
+	if d6 >= 0 && d5 <= d6 {
+		return c
+	}
+	vb := d5*d2 - d1*d6
+	if vb <= 0 && d2 >= 0 && d6 <= 0 {
+		return a.Add(ac.MulScalar(d2 / (d2 - d6)))
+	}
+	va := d3*d6 - d5*d4
+	if va <= 0 && (d4-d3) >= 0 && (d5-d6) >= 0 {
+		return b.Add(c.Sub(b).MulScalar((d4 - d3) / ((d4 - d3) + (d5 - d6))))
+	}
+	denom := 1 / (va + vb + vc)
+	v := vb * denom
+	w := vc * denom
+	return a.Add(ab.MulScalar(v)).Add(ac.MulScalar(w))
+}
+
+// meshSolidAngle returns the solid angle of a triangle viewed from a point.
+// See: "The Solid Angle of a Plane Triangle", Van Oosterom, Strackee
+func meshSolidAngle(t *Triangle3, p V3) float64 {
+	a := t.V[0].Sub(p)
+	b := t.V[1].Sub(p)
+	c := t.V[2].Sub(p)
+	la, lb, lc := a.Length(), b.Length(), c.Length()
+	num := a.Dot(b.Cross(c))
+	den := la*lb*lc + a.Dot(b)*lc + a.Dot(c)*lb + b.Dot(c)*la
+	return 2 * math.Atan2(num, den)
+}
+
+// distance2 returns the distance squared to the closest triangle.
+func (s *MeshSDF3) distance2(p V3) float64 {
+	best := math.Inf(1)
+	var stack [64]int
+	sp := 0
+	stack[sp] = 0
+	sp++
+	for sp > 0 {
+		sp--
+		n := &s.nodes[stack[sp]]
+		if meshBoxDist2(n.bb, p) >= best {
+			continue
+		}
+		if n.left == 0 {
+			for i := n.start; i < n.end; i++ {
+				best = Min(best, meshClosestPoint(&s.t[i], p).Sub(p).Length2())
+			}
+			continue
+		}
+		// visit the closest child first
+		l, r := n.left, n.right
+		if meshBoxDist2(s.nodes[l].bb, p) < meshBoxDist2(s.nodes[r].bb, p) {
+			l, r = r, l
+		}
+		stack[sp] = l
+		stack[sp+1] = r
+		sp += 2
+	}
+	return best
+}
+
+// winding returns the generalized winding number of the mesh at a point.
+func (s *MeshSDF3) winding(p V3) float64 {
+	var w float64
+	var stack [64]int
+	sp := 0
+	stack[sp] = 0
+	sp++
+	for sp > 0 {
+		sp--
+		n := &s.nodes[stack[sp]]
+		d := n.center.Sub(p)
+		if l := d.Length(); l > meshBeta*n.radius {
+			// far away, approximate the node as a dipole
+			w += d.Dot(n.area) / (l * l * l)
+			continue
+		}
+		if n.left == 0 {
+			for i := n.start; i < n.end; i++ {
+				w += meshSolidAngle(&s.t[i], p)
+			}
+			continue
+		}
+		stack[sp] = n.left
+		stack[sp+1] = n.right
+		sp += 2
+	}
+	return w / (4 * Pi)
+}
+
+// Evaluate returns the minimum distance to a triangle mesh.
+func (s *MeshSDF3) Evaluate(p V3) float64 {
+	d := math.Sqrt(s.distance2(p))
+	if s.winding(p) > 0.5 {
+		return -d
+	}
+	return d
+}
+
+// BoundingBox returns the bounding box for a triangle mesh.
+func (s *MeshSDF3) BoundingBox() Box3 {
+	return s.bb
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,279 @@
+//-----------------------------------------------------------------------------
+/*
+
+MSLA Slicing
+
+Resin (MSLA) printers expose each layer of the part as a pixel mask. The
+masks are sampled directly from the SDF3 so no mesh is needed. With
+antialiasing the pixels near the surface are supersampled and set to their
+coverage. Far from the surface the distance at the pixel center is enough.
+
+The masks are written as PNG files in a zip archive along with the layer
+manifest (config.ini and prusaslicer.ini), as used by the open SL1 format.
+These files are read by UVtools which can convert them for other printers.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// MSLAParms defines the parameters for an MSLA printer.
+type MSLAParms struct {
+	LayerHeight    float64     // layer height (mm)
+	Pixels         V2i         // display resolution (pixels)
+	BuildArea      V2          // display size (mm)
+	Antialias      bool        // antialiased (vs binary) layer masks
+	Exposure       float64     // layer exposure time (seconds)
+	BottomExposure float64     // exposure time for the bottom layers (seconds)
+	BottomLayers   int         // number of bottom layers
+	Material       string      // resin material name
+	Printer        string      // printer model (default "SL1")
+	Workers        int         // number of slicing workers (0 == runtime.NumCPU())
+	Logger         *log.Logger // progress logger (nil == quiet)
+}
+
+// logf writes a message to the slicing logger.
+func (k *MSLAParms) logf(format string, args ...interface{}) {
+	if k.Logger != nil {
+		k.Logger.Printf(format, args...)
+	}
+}
+
+// check returns an error for bad parameters.
+func (k *MSLAParms) check() error {
+	if k.LayerHeight <= 0 {
+		return errors.New("LayerHeight must be > 0")
+	}
+	if k.Pixels[0] <= 0 || k.Pixels[1] <= 0 {
+		return errors.New("Pixels must be > 0")
+	}
+	if k.BuildArea.X <= 0 || k.BuildArea.Y <= 0 {
+		return errors.New("BuildArea must be > 0")
+	}
+	return nil
+}
+
+// printer returns the printer model name.
+func (k *MSLAParms) printer() string {
+	if k.Printer == "" {
+		return "SL1"
+	}
+	return k.Printer
+}
+
+//-----------------------------------------------------------------------------
+
+// mslaSamples is the number of antialiasing samples per pixel on each axis.
+const mslaSamples = 4
+
+// mslaSlicer slices an SDF3 into layer masks.
+type mslaSlicer struct {
+	s      SDF3
+	k      *MSLAParms
+	m      *Map2   // pixel to build area coordinates
+	pixel  float64 // pixel size (mm)
+	z0     float64 // z at the bottom of the part
+	layers int     // number of layers
+}
+
+// newMSLASlicer returns a slicer for an SDF3.
+// The part is centered on the build area at x = y = 0.
+func newMSLASlicer(s SDF3, k *MSLAParms) (*mslaSlicer, error) {
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+	area := Box2{k.BuildArea.MulScalar(-0.5), k.BuildArea.MulScalar(0.5)}
+	bb := s.BoundingBox()
+	if bb.Min.X < area.Min.X || bb.Min.Y < area.Min.Y || bb.Max.X > area.Max.X || bb.Max.Y > area.Max.Y {
+		return nil, errors.New("part is larger than the build area")
+	}
+	m, err := NewMap2(area, k.Pixels, true)
+	if err != nil {
+		return nil, err
+	}
+	size := k.BuildArea.Div(k.Pixels.ToV2())
+	return &mslaSlicer{
+		s:      s,
+		k:      k,
+		m:      m,
+		pixel:  0.5 * (size.X + size.Y),
+		z0:     bb.Min.Z,
+		layers: int(math.Ceil(bb.Size().Z / k.LayerHeight)),
+	}, nil
+}
+
+// coverage returns the fraction of a pixel within the part.
+func (sl *mslaSlicer) coverage(p V2, z float64) float64 {
+	delta := sl.m.delta.DivScalar(mslaSamples)
+	p = p.Sub(sl.m.delta.MulScalar(0.5)).Add(delta.MulScalar(0.5))
+	n := 0
+	for i := 0; i < mslaSamples; i++ {
+		for j := 0; j < mslaSamples; j++ {
+			if sl.s.Evaluate(V3{p.X + float64(i)*delta.X, p.Y + float64(j)*delta.Y, z}) <= 0 {
+				n++
+			}
+		}
+	}
+	return float64(n) / (mslaSamples * mslaSamples)
+}
+
+// layer returns the mask for a layer and the number of exposed pixels.
+// Layers are sampled at their mid height.
+func (sl *mslaSlicer) layer(i int) (*image.Gray, float64) {
+	z := sl.z0 + (float64(i)+0.5)*sl.k.LayerHeight
+	img := image.NewGray(image.Rect(0, 0, sl.k.Pixels[0], sl.k.Pixels[1]))
+	// only sample the pixels within the bounding box of the part
+	// (the part is within the build area, so only the far edge can overflow)
+	bb := sl.s.BoundingBox()
+	p0 := sl.m.ToV2i(V2{bb.Min.X, bb.Max.Y})
+	p1 := sl.m.ToV2i(V2{bb.Max.X, bb.Min.Y})
+	for j := range p1 {
+		if p1[j] >= sl.k.Pixels[j] {
+			p1[j] = sl.k.Pixels[j] - 1
+		}
+	}
+
+	exposed := make([]float64, sl.k.Pixels[1])
+	parallelFor(sl.k.Workers, p1[1]-p0[1]+1, func(i int) {
+		y := p0[1] + i
+		for x := p0[0]; x <= p1[0]; x++ {
+			p := sl.m.ToV2(V2i{x, y})
+			d := sl.s.Evaluate(V3{p.X, p.Y, z})
+			var v float64
+			if sl.k.Antialias && Abs(d) < sl.pixel {
+				v = sl.coverage(p, z)
+			} else if d <= 0 {
+				v = 1
+			}
+			img.Pix[y*img.Stride+x] = uint8(math.Round(255 * v))
+			exposed[y] += v
+		}
+	})
+
+	var n float64
+	for _, v := range exposed {
+		n += v
+	}
+	return img, n
+}
+
+//-----------------------------------------------------------------------------
+
+// config writes the SL1 layer manifest.
+func (sl *mslaSlicer) config(w io.Writer, job string, volume float64) error {
+	k := sl.k
+	bottom := k.BottomLayers
+	if bottom > sl.layers {
+		bottom = sl.layers
+	}
+	printTime := float64(bottom)*k.BottomExposure + float64(sl.layers-bottom)*k.Exposure
+	_, err := fmt.Fprintf(w, "action = print\n"+
+		"jobDir = %s\n"+
+		"expTime = %g\n"+
+		"expTimeFirst = %g\n"+
+		"fileCreationTimestamp = %s\n"+
+		"layerHeight = %g\n"+
+		"materialName = %s\n"+
+		"numFade = 0\n"+
+		"numFast = %d\n"+
+		"numSlow = 0\n"+
+		"printProfile = sdfx\n"+
+		"printTime = %g\n"+
+		"printerModel = %s\n"+
+		"usedMaterial = %.3f\n",
+		job, k.Exposure, k.BottomExposure,
+		time.Now().UTC().Format("2006-01-02 at 15:04:05 UTC"),
+		k.LayerHeight, k.Material, sl.layers, printTime, k.printer(), volume)
+	return err
+}
+
+// printerConfig writes the SL1 printer settings.
+func (sl *mslaSlicer) printerConfig(w io.Writer) error {
+	k := sl.k
+	_, err := fmt.Fprintf(w, "display_height = %g\n"+
+		"display_orientation = landscape\n"+
+		"display_pixels_x = %d\n"+
+		"display_pixels_y = %d\n"+
+		"display_width = %g\n"+
+		"exposure_time = %g\n"+
+		"faded_layers = %d\n"+
+		"initial_exposure_time = %g\n"+
+		"layer_height = %g\n"+
+		"printer_model = %s\n"+
+		"printer_technology = SLA\n",
+		k.BuildArea.Y, k.Pixels[0], k.Pixels[1], k.BuildArea.X,
+		k.Exposure, k.BottomLayers, k.BottomExposure, k.LayerHeight, k.printer())
+	return err
+}
+
+//-----------------------------------------------------------------------------
+
+// EncodeMSLA slices an SDF3 and writes the layer masks and manifest as a zip archive.
+// The job name is used to name the layer files.
+func EncodeMSLA(w io.Writer, s SDF3, job string, k *MSLAParms) error {
+	sl, err := newMSLASlicer(s, k)
+	if err != nil {
+		return err
+	}
+	z := zip.NewWriter(w)
+	// layers
+	var pixels float64
+	for i := 0; i < sl.layers; i++ {
+		img, n := sl.layer(i)
+		pixels += n
+		f, err := z.Create(fmt.Sprintf("%s%05d.png", job, i))
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, img); err != nil {
+			return err
+		}
+	}
+	// manifest, the used material is in ml
+	volume := pixels * (k.BuildArea.X / float64(k.Pixels[0])) * (k.BuildArea.Y / float64(k.Pixels[1])) * k.LayerHeight / 1000
+	f, err := z.Create("config.ini")
+	if err != nil {
+		return err
+	}
+	if err := sl.config(f, job, volume); err != nil {
+		return err
+	}
+	f, err = z.Create("prusaslicer.ini")
+	if err != nil {
+		return err
+	}
+	if err := sl.printerConfig(f); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// SaveMSLA slices an SDF3 and writes the layer masks and manifest to a zip (e.g. .sl1) file.
+func SaveMSLA(path string, s SDF3, k *MSLAParms) error {
+	if err := k.check(); err != nil {
+		return err
+	}
+	job := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	k.logf("slicing %s (%d layers)", path, int(math.Ceil(s.BoundingBox().Size().Z/k.LayerHeight)))
+	return saveFile(path, func(w io.Writer) error {
+		return EncodeMSLA(w, s, job, k)
+	})
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,144 @@
+//-----------------------------------------------------------------------------
+/*
+
+Wavefront OBJ Files
+
+Only the geometry is loaded: vertices (v) and faces (f). Texture and normal
+indices on the faces are ignored, and polygonal faces are triangulated as
+fans. Meshes are saved with optional vertex normals (vn).
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+
+// objIndex returns the vertex index for an OBJ face vertex (e.g. "3", "3/1", "3//2", "-1").
+func objIndex(s string, n int) (int, error) {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s = s[:i]
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		// relative to the end of the vertex list
+		i += n
+	} else {
+		i--
+	}
+	if i < 0 || i >= n {
+		return 0, fmt.Errorf("vertex index %s out of range", s)
+	}
+	return i, nil
+}
+
+// DecodeOBJ reads a triangle mesh in Wavefront OBJ format.
+func DecodeOBJ(r io.Reader) ([]*Triangle3, error) {
+	m := &Mesh3{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("bad vertex on line %d", line)
+			}
+			var p [3]float64
+			for i := range p {
+				x, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil {
+					return nil, fmt.Errorf("bad vertex on line %d: %s", line, err)
+				}
+				p[i] = x
+			}
+			m.AddVertex(V3{p[0], p[1], p[2]})
+		case "f":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("bad face on line %d", line)
+			}
+			idx := make([]int, len(fields)-1)
+			for i, s := range fields[1:] {
+				k, err := objIndex(s, len(m.V))
+				if err != nil {
+					return nil, fmt.Errorf("bad face on line %d: %s", line, err)
+				}
+				idx[i] = k
+			}
+			for i := 2; i < len(idx); i++ {
+				m.AddFace(idx[0], idx[i-1], idx[i])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m.Triangles(), nil
+}
+
+// LoadOBJ reads a triangle mesh from a Wavefront OBJ file.
+func LoadOBJ(path string) ([]*Triangle3, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeOBJ(f)
+}
+
+//-----------------------------------------------------------------------------
+
+// EncodeOBJ writes a triangle mesh in Wavefront OBJ format.
+// The vertex normals are optional (nil == no normals).
+func EncodeOBJ(w io.Writer, m *Mesh3, normals []V3) error {
+	if normals != nil && len(normals) != len(m.V) {
+		return errors.New("wrong number of normals")
+	}
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "# sdfx\n")
+	for _, v := range m.V {
+		fmt.Fprintf(buf, "v %s %s %s\n", objFloat(v.X), objFloat(v.Y), objFloat(v.Z))
+	}
+	for _, n := range normals {
+		fmt.Fprintf(buf, "vn %s %s %s\n", objFloat(n.X), objFloat(n.Y), objFloat(n.Z))
+	}
+	for _, f := range m.F {
+		if normals != nil {
+			fmt.Fprintf(buf, "f %d//%d %d//%d %d//%d\n", f[0]+1, f[0]+1, f[1]+1, f[1]+1, f[2]+1, f[2]+1)
+		} else {
+			fmt.Fprintf(buf, "f %d %d %d\n", f[0]+1, f[1]+1, f[2]+1)
+		}
+	}
+	return buf.Flush()
+}
+
+// SaveOBJ writes a triangle mesh to a Wavefront OBJ file.
+func SaveOBJ(path string, m *Mesh3, normals []V3) error {
+	return saveFile(path, func(w io.Writer) error {
+		return EncodeOBJ(w, m, normals)
+	})
+}
+
+// objFloat formats a number for an OBJ file.
+func objFloat(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 32)
+}
+
+//-----------------------------------------------------------------------------
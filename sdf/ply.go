@@ -0,0 +1,126 @@
+//-----------------------------------------------------------------------------
+/*
+
+PLY Files
+
+Triangle meshes are written in binary little endian PLY format. Each vertex
+can carry a normal, a color and a scalar value (e.g. an analysis result).
+These are displayed by MeshLab and most other mesh viewers.
+
+See: http://paulbourke.net/dataformats/ply/
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+// PLYParms are the optional per-vertex attributes for a PLY file.
+type PLYParms struct {
+	Normals    []V3          // vertex normals (nil == no normals)
+	Colors     []color.Color // vertex colors (nil == no colors)
+	Scalar     []float64     // vertex scalar values (nil == no scalar)
+	ScalarName string        // property name for the scalar values (default "quality")
+}
+
+// check returns an error if the attributes don't match the mesh.
+func (k *PLYParms) check(m *Mesh3) error {
+	n := len(m.V)
+	if k.Normals != nil && len(k.Normals) != n {
+		return errors.New("wrong number of normals")
+	}
+	if k.Colors != nil && len(k.Colors) != n {
+		return errors.New("wrong number of colors")
+	}
+	if k.Scalar != nil && len(k.Scalar) != n {
+		return errors.New("wrong number of scalar values")
+	}
+	return nil
+}
+
+// EncodePLY writes a triangle mesh in binary PLY format.
+// The vertex attributes are optional (nil == geometry only).
+func EncodePLY(w io.Writer, m *Mesh3, k *PLYParms) error {
+	if k == nil {
+		k = &PLYParms{}
+	}
+	if err := k.check(m); err != nil {
+		return err
+	}
+	scalarName := k.ScalarName
+	if scalarName == "" {
+		scalarName = "quality"
+	}
+
+	buf := bufio.NewWriter(w)
+	// header
+	fmt.Fprintf(buf, "ply\nformat binary_little_endian 1.0\ncomment sdfx\n")
+	fmt.Fprintf(buf, "element vertex %d\n", len(m.V))
+	fmt.Fprintf(buf, "property float x\nproperty float y\nproperty float z\n")
+	if k.Normals != nil {
+		fmt.Fprintf(buf, "property float nx\nproperty float ny\nproperty float nz\n")
+	}
+	if k.Colors != nil {
+		fmt.Fprintf(buf, "property uchar red\nproperty uchar green\nproperty uchar blue\nproperty uchar alpha\n")
+	}
+	if k.Scalar != nil {
+		fmt.Fprintf(buf, "property float %s\n", scalarName)
+	}
+	fmt.Fprintf(buf, "element face %d\n", len(m.F))
+	fmt.Fprintf(buf, "property list uchar int vertex_indices\nend_header\n")
+
+	// vertices
+	var b [4]byte
+	putFloat := func(x float64) {
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(float32(x)))
+		buf.Write(b[:])
+	}
+	for i, v := range m.V {
+		putFloat(v.X)
+		putFloat(v.Y)
+		putFloat(v.Z)
+		if k.Normals != nil {
+			n := k.Normals[i]
+			putFloat(n.X)
+			putFloat(n.Y)
+			putFloat(n.Z)
+		}
+		if k.Colors != nil {
+			c := color.NRGBAModel.Convert(k.Colors[i]).(color.NRGBA)
+			buf.Write([]byte{c.R, c.G, c.B, c.A})
+		}
+		if k.Scalar != nil {
+			putFloat(k.Scalar[i])
+		}
+	}
+
+	// faces
+	for _, f := range m.F {
+		buf.WriteByte(3)
+		for _, j := range f {
+			binary.LittleEndian.PutUint32(b[:], uint32(j))
+			buf.Write(b[:])
+		}
+	}
+	return buf.Flush()
+}
+
+// SavePLY writes a triangle mesh to a binary PLY file.
+func SavePLY(path string, m *Mesh3, k *PLYParms) error {
+	return saveFile(path, func(w io.Writer) error {
+		return EncodePLY(w, m, k)
+	})
+}
+
+//-----------------------------------------------------------------------------
@@ -3,6 +3,11 @@
 
 2D Rendering Code
 
+A 2d distance field can be rendered as a gray scale, a diverging colormap
+(inside and outside colors fading to white at the surface) or the gradient
+magnitude (white where |grad| = 1, red where it is larger, blue where it is
+smaller). Periodic isoline bands and the zero contour can be overlaid.
+
 */
 //-----------------------------------------------------------------------------
 
@@ -12,7 +17,8 @@ import (
 	"image"
 	"image/color"
 	"image/png"
-	"os"
+	"io"
+	"math"
 
 	"github.com/llgcode/draw2d/draw2dimg"
 )
@@ -43,29 +49,125 @@ func NewPNG(name string, bb Box2, pixels V2i) (*PNG, error) {
 	return &d, nil
 }
 
+// PNGMode is the colormap for rendering a distance field.
+type PNGMode int
+
+// PNG rendering modes.
+const (
+	PNGGray      PNGMode = iota // distance range as gray scale
+	PNGDiverging                // inside and outside colors
+	PNGGradient                 // gradient magnitude
+)
+
+// PNGStyle defines how a distance field is rendered.
+type PNGStyle struct {
+	Mode          PNGMode
+	Inside        color.Color // inside color for PNGDiverging (nil == blue)
+	Outside       color.Color // outside color for PNGDiverging (nil == orange)
+	DistanceRange float64     // distance for the full color with PNGDiverging (0 == auto)
+	GradientRange float64     // |grad| - 1 for the full color with PNGGradient (0 == auto)
+	Isolines      float64     // spacing of the isoline bands (0 == none)
+	Zero          color.Color // zero contour color (nil == none)
+}
+
+// pngLerp returns the color a fraction t of the way from c0 to c1.
+func pngLerp(c0, c1 color.NRGBA, t float64) color.NRGBA {
+	t = Clamp(t, 0, 1)
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + t*(float64(b)-float64(a))))
+	}
+	return color.NRGBA{mix(c0.R, c1.R), mix(c0.G, c1.G), mix(c0.B, c1.B), 0xff}
+}
+
+// pngColor returns a color, or a default for nil.
+func pngColor(c, def color.Color) color.NRGBA {
+	if c == nil {
+		c = def
+	}
+	return color.NRGBAModel.Convert(c).(color.NRGBA)
+}
+
 // RenderSDF2 renders a 2d signed distance field as gray scale.
 func (d *PNG) RenderSDF2(s SDF2) {
-	// sample the distance field
-	var dmax, dmin float64
-	distance := make([]float64, d.pixels[0]*d.pixels[1])
-	xofs := 0
-	for x := 0; x < d.pixels[0]; x++ {
-		for y := 0; y < d.pixels[1]; y++ {
-			d := s.Evaluate(d.m.ToV2(V2i{x, y}))
-			dmax = Max(dmax, d)
-			dmin = Min(dmin, d)
-			distance[xofs+y] = d
+	d.RenderSDF2Style(s, &PNGStyle{})
+}
+
+// RenderSDF2Style renders a 2d signed distance field with a colormap and overlays.
+// Use a nil style for gray scale.
+func (d *PNG) RenderSDF2Style(s SDF2, k *PNGStyle) {
+	if k == nil {
+		k = &PNGStyle{}
+	}
+	// sample the distance field and gradient magnitude
+	nx, ny := d.pixels[0], d.pixels[1]
+	gk := &GradientParms{Step: 0.5 * d.m.delta.MinComponent()}
+	distance := make([]float64, nx*ny)
+	gradient := make([]float64, nx*ny)
+	var dmax, dmin, gmax float64
+	for x := 0; x < nx; x++ {
+		for y := 0; y < ny; y++ {
+			p := d.m.ToV2(V2i{x, y})
+			v := s.Evaluate(p)
+			dmax = Max(dmax, v)
+			dmin = Min(dmin, v)
+			distance[x*ny+y] = v
+			if k.Mode == PNGGradient || k.Zero != nil {
+				g := Gradient2(s, p, gk).Length()
+				gmax = Max(gmax, Abs(g-1))
+				gradient[x*ny+y] = g
+			}
 		}
-		xofs += d.pixels[1]
 	}
-	// scale and set the pixel values
-	xofs = 0
-	for x := 0; x < d.pixels[0]; x++ {
-		for y := 0; y < d.pixels[1]; y++ {
-			val := 255.0 * ((distance[xofs+y] - dmin) / (dmax - dmin))
-			d.img.Set(x, y, color.Gray{uint8(val)})
+
+	white := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	inside := pngColor(k.Inside, color.NRGBA{0x21, 0x66, 0xac, 0xff})
+	outside := pngColor(k.Outside, color.NRGBA{0xe6, 0x61, 0x01, 0xff})
+	drange := k.DistanceRange
+	if drange <= 0 {
+		drange = Max(-dmin, dmax)
+	}
+	grange := k.GradientRange
+	if grange <= 0 {
+		grange = Max(gmax, 0.1)
+	}
+	// the zero contour is about 1.5 pixels wide
+	width := 1.5 * d.m.delta.MaxComponent()
+
+	for x := 0; x < nx; x++ {
+		for y := 0; y < ny; y++ {
+			v := distance[x*ny+y]
+			g := gradient[x*ny+y]
+			var c color.NRGBA
+			switch k.Mode {
+			case PNGDiverging:
+				if v < 0 {
+					c = pngLerp(white, inside, -v/drange)
+				} else {
+					c = pngLerp(white, outside, v/drange)
+				}
+			case PNGGradient:
+				if g > 1 {
+					c = pngLerp(white, color.NRGBA{0xd7, 0x19, 0x1c, 0xff}, (g-1)/grange)
+				} else {
+					c = pngLerp(white, color.NRGBA{0x2c, 0x7b, 0xb6, 0xff}, (1-g)/grange)
+				}
+			default:
+				gray := uint8(255.0 * ((v - dmin) / (dmax - dmin)))
+				c = color.NRGBA{gray, gray, gray, 0xff}
+			}
+			if k.Isolines > 0 {
+				// darken the color periodically with the distance
+				c = pngLerp(c, color.NRGBA{0, 0, 0, 0xff}, 0.15*(1+math.Cos(Tau*v/k.Isolines)))
+			}
+			if k.Zero != nil {
+				// use the distance in pixels, corrected for the gradient
+				if g > 0 {
+					v /= g
+				}
+				c = pngLerp(pngColor(k.Zero, nil), c, Abs(v)/width)
+			}
+			d.img.Set(x, y, c)
 		}
-		xofs += d.pixels[1]
 	}
 }
 
@@ -104,15 +206,14 @@ func (d *PNG) Triangle(t Triangle2) {
 	d.Lines([]V2{t[0], t[1], t[2], t[0]})
 }
 
+// Encode writes a png object to a writer.
+func (d *PNG) Encode(w io.Writer) error {
+	return png.Encode(w, d.img)
+}
+
 // Save saves a png object to a file.
 func (d *PNG) Save() error {
-	f, err := os.Create(d.name)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	png.Encode(f, d.img)
-	return nil
+	return saveFile(d.name, d.Encode)
 }
 
 //-----------------------------------------------------------------------------
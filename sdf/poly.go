@@ -340,33 +340,180 @@ func (p *Polygon) Vertices() []V2 {
 }
 
 // Render outputs a polygon as a 2D DXF file.
+// Arcs and smoothed vertices are written as polyline arc segments.
 func (p *Polygon) Render(path string) error {
 	if p.vlist == nil {
 		return fmt.Errorf("no vertices")
 	}
-	p.fixups()
 	fmt.Printf("rendering %s\n", path)
 	d := NewDXF(path)
-	for i := 0; i < len(p.vlist)-1; i++ {
-		if p.vlist[i+1].vtype != pvHide {
-			p0 := p.vlist[i].vertex
-			p1 := p.vlist[i+1].vertex
-			d.Line(p0, p1)
+	d.Polygon(p)
+	return d.Save()
+}
+
+//-----------------------------------------------------------------------------
+// polylines with arc segments
+
+// polyline is a set of points with arc segments.
+// The bulge of a point is tan(theta/4) for the arc from that point to the
+// next point, where theta is the included angle (> 0 == counter-clockwise).
+type polyline struct {
+	points V2Set
+	bulge  []float64
+	closed bool
+}
+
+// add adds a point to a polyline.
+func (pl *polyline) add(v V2, bulge float64) {
+	pl.points = append(pl.points, v)
+	pl.bulge = append(pl.bulge, bulge)
+}
+
+// reverse reverses the direction of a polyline.
+func (pl *polyline) reverse() {
+	n := len(pl.points)
+	points := make(V2Set, n)
+	bulge := make([]float64, n)
+	for i := range pl.points {
+		points[n-1-i] = pl.points[i]
+	}
+	// the bulge of the segment i -> i+1 moves to the point i+1
+	for i := range pl.bulge {
+		j := (i + 1) % n
+		if !pl.closed && j == 0 {
+			continue
+		}
+		bulge[n-1-j] = -pl.bulge[i]
+	}
+	pl.points = points
+	pl.bulge = bulge
+}
+
+// arcBulge returns the bulge for an arc vertex.
+// See arcVertex.
+func arcBulge(a, b V2, radius float64) float64 {
+	side := Sign(radius)
+	r := Abs(radius)
+	ba := b.Sub(a).Normalize()
+	n := V2{ba.Y, -ba.X}.MulScalar(side)
+	mid := a.Add(b).MulScalar(0.5)
+	dMid := mid.Sub(a).Length()
+	c := mid.Add(n.MulScalar(math.Sqrt((r * r) - (dMid * dMid))))
+	ac := a.Sub(c).Normalize()
+	bc := b.Sub(c).Normalize()
+	return math.Tan(-side * math.Acos(Clamp(ac.Dot(bc), -1, 1)) / 4)
+}
+
+// polylines returns the polygon as polylines with arc segments.
+// Hidden segments split the polygon into open polylines. Polygons with
+// smoothed vertices next to arcs are returned as line segments.
+func (p *Polygon) polylines() []*polyline {
+	q := Polygon{closed: p.closed, vlist: append([]PolygonVertex(nil), p.vlist...)}
+	q.relToAbs()
+	// check for smoothing next to an arc
+	for i := range q.vlist {
+		vn := q.nextVertex(i)
+		if q.vlist[i].vtype == pvSmooth && vn != nil && vn.vtype == pvArc {
+			q.fixups()
+			pl := &polyline{closed: p.closed}
+			for _, v := range q.vlist {
+				pl.add(v.vertex, 0)
+			}
+			return p.splitHidden(pl, q.vlist)
 		}
 	}
-	// close the polygon if needed
-	if p.closed {
-		p0 := p.vlist[len(p.vlist)-1].vertex
-		p1 := p.vlist[0].vertex
-		if !p0.Equals(p1, tolerance) {
-			d.Line(p0, p1)
+	pl := &polyline{closed: p.closed}
+	var vlist []PolygonVertex // vertex types for the polyline points
+	var closing float64       // bulge for the closing segment
+	for i, v := range q.vlist {
+		vp, vn := q.prevVertex(i), q.nextVertex(i)
+		switch {
+		case v.vtype == pvArc && vp != nil:
+			// the arc is from the previous point to this point
+			bulge := arcBulge(vp.vertex, v.vertex, v.radius)
+			if i == 0 {
+				closing = bulge
+			} else {
+				pl.bulge[len(pl.bulge)-1] = bulge
+			}
+			pl.add(v.vertex, 0)
+			vlist = append(vlist, v)
+		case v.vtype == pvSmooth && vp != nil && vn != nil:
+			// see smoothVertex
+			v0 := vp.vertex.Sub(v.vertex).Normalize()
+			v1 := vn.vertex.Sub(v.vertex).Normalize()
+			theta := math.Acos(Clamp(v0.Dot(v1), -1, 1))
+			d1 := v.radius / math.Tan(theta/2.0)
+			if d1 > vp.vertex.Sub(v.vertex).Length() || d1 > vn.vertex.Sub(v.vertex).Length() {
+				// unable to smooth - radius is too large
+				pl.add(v.vertex, 0)
+				vlist = append(vlist, v)
+				break
+			}
+			var bulge float64
+			if v.facets > 1 {
+				bulge = math.Tan(Sign(v1.Cross(v0)) * (Pi - theta) / 4)
+			}
+			pl.add(v.vertex.Add(v0.MulScalar(d1)), bulge)
+			pl.add(v.vertex.Add(v1.MulScalar(d1)), 0)
+			vlist = append(vlist, v, PolygonVertex{})
+		default:
+			pl.add(v.vertex, 0)
+			vlist = append(vlist, v)
 		}
 	}
-	err := d.Save()
-	if err != nil {
-		return err
+	if closing != 0 {
+		pl.bulge[len(pl.bulge)-1] = closing
+	}
+	return p.splitHidden(pl, vlist)
+}
+
+// splitHidden splits a polyline at the hidden segments and applies the
+// polygon direction. vlist has the vertex types for the polyline points.
+// As with Render, the segment ending at a hidden vertex is not drawn.
+func (p *Polygon) splitHidden(pl *polyline, vlist []PolygonVertex) []*polyline {
+	n := len(pl.points)
+	// drop a repeated closing point
+	if pl.closed && n > 1 && pl.points[0].Equals(pl.points[n-1], tolerance) && pl.bulge[n-1] == 0 {
+		pl.points, pl.bulge, vlist = pl.points[:n-1], pl.bulge[:n-1], vlist[:n-1]
+		n--
+	}
+	// find the first hidden segment
+	start := -1
+	for i := 1; i < n; i++ {
+		if vlist[i].vtype == pvHide {
+			start = i
+			break
+		}
+	}
+	out := []*polyline{pl}
+	if start >= 0 {
+		// start a new open polyline at each hidden segment
+		if !pl.closed {
+			start = 0
+		}
+		out = nil
+		cur := &polyline{}
+		for k := 0; k < n; k++ {
+			i := (start + k) % n
+			if i != 0 && vlist[i].vtype == pvHide && len(cur.points) > 0 {
+				if len(cur.points) > 1 {
+					out = append(out, cur)
+				}
+				cur = &polyline{}
+			}
+			cur.add(pl.points[i], pl.bulge[i])
+		}
+		if len(cur.points) > 1 {
+			out = append(out, cur)
+		}
+	}
+	if p.reverse {
+		for _, x := range out {
+			x.reverse()
+		}
 	}
-	return nil
+	return out
 }
 
 //-----------------------------------------------------------------------------
@@ -0,0 +1,337 @@
+//-----------------------------------------------------------------------------
+/*
+
+Ray Marched Previews
+
+Renders a shaded image of an SDF3 without meshing it. Rays from the camera
+are sphere traced (stepped by the distance to the surface) until they hit the
+surface. The surface normal is the gradient of the distance field. Shading is
+Lambert (diffuse) lighting from above the camera with ambient occlusion,
+estimated by sampling the distance field along the normal.
+
+Edge outlines are drawn where neighbouring pixels are not on the same
+tangent plane (silhouettes and steps) or their normals differ (creases).
+
+See: https://iquilezles.org/articles/raymarchingdf/
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+
+// PreviewParms defines the camera and shading for a preview image.
+// The camera orbits the center of the bounding box. With zero angles it
+// looks along the y axis with z up.
+type PreviewParms struct {
+	Size       V2i         // image size (pixels, default 512x512)
+	Azimuth    float64     // camera rotation about the z axis (radians)
+	Elevation  float64     // camera angle above the xy plane (radians)
+	FOV        float64     // vertical field of view (radians, 0 == orthographic)
+	Color      color.Color // surface color (nil == light gray)
+	Background color.Color // background color (nil == white)
+	Outline    bool        // draw edge outlines
+	Workers    int         // number of render workers (0 == runtime.NumCPU())
+}
+
+// size returns the image size.
+func (k *PreviewParms) size() V2i {
+	if k.Size[0] <= 0 || k.Size[1] <= 0 {
+		return V2i{512, 512}
+	}
+	return k.Size
+}
+
+//-----------------------------------------------------------------------------
+
+const (
+	previewSteps     = 256   // maximum sphere tracing steps per ray
+	previewAmbient   = 0.3   // ambient light
+	previewAOSamples = 5     // ambient occlusion samples
+	previewCrease    = 0.866 // cos(crease angle) for outlines
+	previewMargin    = 1.05  // image margin about the bounding sphere
+)
+
+// previewCamera generates the rays for an image.
+type previewCamera struct {
+	eye      V3      // camera position (perspective)
+	fwd      V3      // view direction
+	right    V3      // image x direction
+	up       V3      // image y direction
+	scale    V2      // image plane half size (orthographic) or tan(half angle)
+	ortho    bool    // orthographic projection
+	distance float64 // camera distance from the center
+}
+
+// newPreviewCamera returns the camera for an SDF3.
+func newPreviewCamera(bb Box3, size V2i, k *PreviewParms) *previewCamera {
+	c := &previewCamera{}
+	center := bb.Center()
+	radius := 0.5 * bb.Size().Length() * previewMargin
+	ce, se := math.Cos(k.Elevation), math.Sin(k.Elevation)
+	ca, sa := math.Cos(k.Azimuth), math.Sin(k.Azimuth)
+	back := V3{sa * ce, -ca * ce, se} // from the center towards the camera
+	c.fwd = back.Neg()
+	c.right = c.fwd.Cross(V3{0, 0, 1})
+	if c.right.Length() < epsilon {
+		// looking straight up or down
+		c.right = V3{ca, sa, 0}
+	}
+	c.right = c.right.Normalize()
+	c.up = c.right.Cross(c.fwd)
+	aspect := float64(size[0]) / float64(size[1])
+	if k.FOV <= 0 {
+		c.ortho = true
+		c.distance = 2 * radius
+		c.scale = V2{aspect * radius, radius}
+	} else {
+		t := math.Tan(0.5 * k.FOV)
+		c.scale = V2{aspect * t, t}
+		// fit the bounding sphere within the narrower field of view
+		c.distance = radius / math.Sin(math.Atan(math.Min(c.scale.X, c.scale.Y)))
+	}
+	if c.ortho && aspect < 1 {
+		c.scale = c.scale.DivScalar(aspect)
+	}
+	c.eye = center.Add(back.MulScalar(c.distance))
+	return c
+}
+
+// ray returns the ray for an image position (-1..1 on each axis).
+func (c *previewCamera) ray(x, y float64) (V3, V3) {
+	if c.ortho {
+		o := c.eye.Add(c.right.MulScalar(x * c.scale.X)).Add(c.up.MulScalar(y * c.scale.Y))
+		return o, c.fwd
+	}
+	d := c.fwd.Add(c.right.MulScalar(x * c.scale.X)).Add(c.up.MulScalar(y * c.scale.Y))
+	return c.eye, d.Normalize()
+}
+
+// rayBox returns the ray parameter range within a box.
+func rayBox(o, d V3, bb Box3) (float64, float64, bool) {
+	t0, t1 := 0.0, math.MaxFloat64
+	oa, da := [3]float64{o.X, o.Y, o.Z}, [3]float64{d.X, d.Y, d.Z}
+	lo, hi := [3]float64{bb.Min.X, bb.Min.Y, bb.Min.Z}, [3]float64{bb.Max.X, bb.Max.Y, bb.Max.Z}
+	for i := range oa {
+		oi, di := oa[i], da[i]
+		if Abs(di) < epsilon {
+			if oi < lo[i] || oi > hi[i] {
+				return 0, 0, false
+			}
+			continue
+		}
+		a, b := (lo[i]-oi)/di, (hi[i]-oi)/di
+		if a > b {
+			a, b = b, a
+		}
+		t0, t1 = math.Max(t0, a), math.Min(t1, b)
+	}
+	return t0, t1, t0 <= t1
+}
+
+//-----------------------------------------------------------------------------
+
+// previewer renders an SDF3.
+type previewer struct {
+	s       SDF3
+	k       *PreviewParms
+	bb      Box3 // bounding box, slightly enlarged
+	cam     *previewCamera
+	size    V2i     // image size
+	hit     float64 // surface hit distance
+	delta   float64 // gradient step
+	ao      float64 // ambient occlusion step
+	light   V3      // light direction (towards the light)
+	color   [3]float64
+	hits    []bool    // did the ray for each pixel hit the surface?
+	depth   []float64 // ray distance for each pixel
+	points  []V3      // surface point for each pixel
+	normals []V3      // surface normal for each pixel
+}
+
+// newPreviewer returns a previewer for an SDF3.
+func newPreviewer(s SDF3, k *PreviewParms) *previewer {
+	bb := s.BoundingBox()
+	size := k.size()
+	radius := 0.5 * bb.Size().Length()
+	p := &previewer{
+		s:     s,
+		k:     k,
+		bb:    NewBox3(bb.Center(), bb.Size().MulScalar(1.02).AddScalar(1e-3*radius)),
+		cam:   newPreviewCamera(bb, size, k),
+		size:  size,
+		hit:   1e-4 * radius,
+		delta: 1e-4 * radius,
+		ao:    0.02 * radius,
+	}
+	// light from above and to the left of the camera
+	p.light = p.cam.fwd.Neg().Add(p.cam.up.MulScalar(0.8)).Sub(p.cam.right.MulScalar(0.5)).Normalize()
+	c := k.Color
+	if c == nil {
+		c = color.Gray{0xd0}
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	p.color = [3]float64{float64(n.R), float64(n.G), float64(n.B)}
+	p.hits = make([]bool, size[0]*size[1])
+	p.depth = make([]float64, size[0]*size[1])
+	p.points = make([]V3, size[0]*size[1])
+	p.normals = make([]V3, size[0]*size[1])
+	return p
+}
+
+// trace returns the distance along a ray to the surface.
+func (p *previewer) trace(o, d V3) (float64, bool) {
+	t0, t1, ok := rayBox(o, d, p.bb)
+	if !ok {
+		return 0, false
+	}
+	t := t0
+	for i := 0; i < previewSteps && t <= t1; i++ {
+		dist := p.s.Evaluate(o.Add(d.MulScalar(t)))
+		if dist < p.hit {
+			return t, true
+		}
+		t += dist
+	}
+	return 0, false
+}
+
+// normal returns the surface normal at a point.
+func (p *previewer) normal(x V3) V3 {
+	return Normal3(p.s, x, &GradientParms{Step: p.delta})
+}
+
+// occlusion returns the ambient occlusion at a surface point (1 == none).
+func (p *previewer) occlusion(x, n V3) float64 {
+	var occ float64
+	w := 1.0
+	for i := 1; i <= previewAOSamples; i++ {
+		h := float64(i) * p.ao
+		occ += w * (h - p.s.Evaluate(x.Add(n.MulScalar(h)))) / h
+		w *= 0.5
+	}
+	return Clamp(1-0.5*occ, 0, 1)
+}
+
+// pixel traces and shades a pixel.
+func (p *previewer) pixel(img *image.RGBA, x, y int, bg color.NRGBA) {
+	i := y*p.size[0] + x
+	// pixel centers, y is up
+	u := 2*(float64(x)+0.5)/float64(p.size[0]) - 1
+	v := 1 - 2*(float64(y)+0.5)/float64(p.size[1])
+	o, d := p.cam.ray(u, v)
+	t, ok := p.trace(o, d)
+	if !ok {
+		img.Set(x, y, bg)
+		return
+	}
+	hit := o.Add(d.MulScalar(t))
+	n := p.normal(hit)
+	p.hits[i] = true
+	p.depth[i] = t
+	p.points[i] = hit
+	p.normals[i] = n
+	// lambert with ambient occlusion
+	diffuse := math.Max(0, n.Dot(p.light))
+	shade := p.occlusion(hit, n) * (previewAmbient + (1-previewAmbient)*diffuse)
+	img.Set(x, y, color.NRGBA{
+		uint8(Clamp(shade*p.color[0], 0, 255)),
+		uint8(Clamp(shade*p.color[1], 0, 255)),
+		uint8(Clamp(shade*p.color[2], 0, 255)),
+		0xff,
+	})
+}
+
+// edge returns true if a pixel is on a silhouette, step or crease edge.
+func (p *previewer) edge(x, y int) bool {
+	i := y*p.size[0] + x
+	for _, o := range [][2]int{{1, 0}, {0, 1}} {
+		x1, y1 := x+o[0], y+o[1]
+		if x1 >= p.size[0] || y1 >= p.size[1] {
+			continue
+		}
+		j := y1*p.size[0] + x1
+		if p.hits[i] != p.hits[j] {
+			return true
+		}
+		if !p.hits[i] {
+			continue
+		}
+		// the pixel size at the surface
+		pixel := 2 * p.cam.scale.Y / float64(p.size[1])
+		if !p.cam.ortho {
+			pixel *= p.depth[i]
+		}
+		// distance from each point to the tangent plane of the other
+		v := p.points[j].Sub(p.points[i])
+		step := math.Min(Abs(p.normals[i].Dot(v)), Abs(p.normals[j].Dot(v)))
+		if step > 2*pixel || p.normals[i].Dot(p.normals[j]) < previewCrease {
+			return true
+		}
+	}
+	return false
+}
+
+// render renders the image.
+func (p *previewer) render() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, p.size[0], p.size[1]))
+	bg := p.k.Background
+	if bg == nil {
+		bg = color.White
+	}
+	bgc := color.NRGBAModel.Convert(bg).(color.NRGBA)
+	// render the rows in parallel
+	parallelFor(p.k.Workers, p.size[1], func(y int) {
+		for x := 0; x < p.size[0]; x++ {
+			p.pixel(img, x, y, bgc)
+		}
+	})
+	// outlines
+	if p.k.Outline {
+		var edges []image.Point
+		for y := 0; y < p.size[1]; y++ {
+			for x := 0; x < p.size[0]; x++ {
+				if p.edge(x, y) {
+					edges = append(edges, image.Point{x, y})
+				}
+			}
+		}
+		for _, e := range edges {
+			img.Set(e.X, e.Y, color.Black)
+		}
+	}
+	return img
+}
+
+//-----------------------------------------------------------------------------
+
+// Preview returns a shaded image of an SDF3.
+func Preview(s SDF3, k *PreviewParms) *image.RGBA {
+	if k == nil {
+		k = &PreviewParms{}
+	}
+	return newPreviewer(s, k).render()
+}
+
+// EncodePreview writes a shaded image of an SDF3 to a writer in PNG format.
+func EncodePreview(w io.Writer, s SDF3, k *PreviewParms) error {
+	return png.Encode(w, Preview(s, k))
+}
+
+// SavePreview writes a shaded image of an SDF3 to a PNG file.
+func SavePreview(path string, s SDF3, k *PreviewParms) error {
+	return saveFile(path, func(w io.Writer) error {
+		return EncodePreview(w, s, k)
+	})
+}
+
+//-----------------------------------------------------------------------------
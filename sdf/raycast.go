@@ -0,0 +1,156 @@
+//-----------------------------------------------------------------------------
+/*
+
+Ray Casting
+
+Finds where a ray meets the surface of an SDF3. The ray is clipped to the
+bounding box and sphere traced: each step advances by the distance to the
+surface, which can't overshoot it.
+
+To find every entry and exit the trace carries on through the object,
+stepping by the unsigned distance (with a minimum step to get off the
+surface) and refining the crossing wherever the sign of the distance changes.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import "math"
+
+//-----------------------------------------------------------------------------
+
+// RayParms are the parameters for ray casting.
+type RayParms struct {
+	MaxSteps    int     // maximum number of tracing steps (0 == 1024)
+	Epsilon     float64 // surface hit distance (0 == 1e-6 of the bounding box size)
+	MaxDistance float64 // maximum distance along the ray (0 == no limit)
+}
+
+// RayHit is an intersection of a ray with the surface.
+type RayHit struct {
+	T      float64 // distance along the ray
+	Point  V3      // surface point
+	Normal V3      // outward surface normal
+	Enter  bool    // the ray enters the object (false == leaves)
+}
+
+const (
+	raySteps      = 1024 // default maximum tracing steps
+	rayIterations = 16   // crossing refinement iterations
+)
+
+// rayTracer traces rays against an SDF3.
+type rayTracer struct {
+	s     SDF3
+	o, d  V3      // ray origin and unit direction
+	t0    float64 // start of the ray within the bounding box
+	t1    float64 // end of the ray within the bounding box
+	eps   float64 // surface hit distance
+	steps int     // remaining tracing steps
+}
+
+// newRayTracer returns a tracer for a ray, or nil if the ray misses the bounding box.
+func newRayTracer(s SDF3, o, d V3, k *RayParms) *rayTracer {
+	if d.Length2() == 0 {
+		return nil
+	}
+	if k == nil {
+		k = &RayParms{}
+	}
+	bb := s.BoundingBox()
+	r := &rayTracer{
+		s:     s,
+		o:     o,
+		d:     d.Normalize(),
+		eps:   k.Epsilon,
+		steps: k.MaxSteps,
+	}
+	if r.eps <= 0 {
+		r.eps = 1e-6 * bb.Size().MaxComponent()
+	}
+	if r.steps <= 0 {
+		r.steps = raySteps
+	}
+	// enlarge the box so surfaces on it are hit
+	bb = NewBox3(bb.Center(), bb.Size().AddScalar(4*r.eps))
+	t0, t1, ok := rayBox(r.o, r.d, bb)
+	if !ok {
+		return nil
+	}
+	if k.MaxDistance > 0 {
+		t1 = math.Min(t1, k.MaxDistance)
+	}
+	if t0 > t1 {
+		return nil
+	}
+	r.t0, r.t1 = t0, t1
+	return r
+}
+
+// hit returns the ray hit at t.
+func (r *rayTracer) hit(t float64) RayHit {
+	p := r.o.Add(r.d.MulScalar(t))
+	n := Normal3(r.s, p, &GradientParms{Step: r.eps})
+	return RayHit{T: t, Point: p, Normal: n, Enter: n.Dot(r.d) < 0}
+}
+
+// first returns the first hit along the ray.
+func (r *rayTracer) first() (RayHit, bool) {
+	t := r.t0
+	for ; r.steps > 0 && t <= r.t1; r.steps-- {
+		dist := Abs(r.s.Evaluate(r.o.Add(r.d.MulScalar(t))))
+		if dist < r.eps {
+			return r.hit(t), true
+		}
+		t += dist
+	}
+	return RayHit{}, false
+}
+
+// all returns every surface crossing along the ray.
+func (r *rayTracer) all() []RayHit {
+	var hits []RayHit
+	t := r.t0
+	v := r.s.Evaluate(r.o.Add(r.d.MulScalar(t)))
+	for ; r.steps > 0 && t < r.t1; r.steps-- {
+		tn := math.Min(t+math.Max(Abs(v), r.eps), r.t1)
+		vn := r.s.Evaluate(r.o.Add(r.d.MulScalar(tn)))
+		if (v < 0) != (vn < 0) {
+			// the sign changed, refine the crossing
+			p0, p1 := r.o.Add(r.d.MulScalar(t)), r.o.Add(r.d.MulScalar(tn))
+			p := mcRefine(r.s, p0, p1, v, vn, 0, rayIterations)
+			h := r.hit(p.Sub(r.o).Dot(r.d))
+			h.Enter = vn < 0
+			hits = append(hits, h)
+		}
+		t, v = tn, vn
+	}
+	return hits
+}
+
+//-----------------------------------------------------------------------------
+
+// Raycast3 returns the first intersection of a ray (origin o, direction d)
+// with the surface of an SDF3. A ray starting inside the object hits the
+// surface where it leaves.
+func Raycast3(s SDF3, o, d V3, k *RayParms) (RayHit, bool) {
+	r := newRayTracer(s, o, d, k)
+	if r == nil {
+		return RayHit{}, false
+	}
+	return r.first()
+}
+
+// RaycastAll3 returns every intersection of a ray (origin o, direction d)
+// with the surface of an SDF3, ordered along the ray. Rays that only touch
+// the surface without crossing it are not reported.
+func RaycastAll3(s SDF3, o, d V3, k *RayParms) []RayHit {
+	r := newRayTracer(s, o, d, k)
+	if r == nil {
+		return nil
+	}
+	return r.all()
+}
+
+//-----------------------------------------------------------------------------
@@ -7,18 +7,64 @@ SDF3 -> STL file
 SDF2 -> DXF file
 SDF2 -> SVG file
 
+The ToSTL, ToDXF and ToSVG functions take a set of render options, return
+errors and report render statistics. They don't print anything unless a
+logger is given. Use a Renderer for cancellation and progress reporting.
+
 */
 //-----------------------------------------------------------------------------
 
 package sdf
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"sync"
 )
 
 //-----------------------------------------------------------------------------
 
+// meshResolution returns the sampling resolution for meshCells on the longest axis.
+func meshResolution(s SDF3, meshCells int) (float64, V3i) {
+	bbSize := s.BoundingBox().Size()
+	resolution := bbSize.MaxComponent() / float64(meshCells)
+	cells := bbSize.DivScalar(resolution).ToV3i()
+	return resolution, cells
+}
+
+// RenderMesh3 renders an SDF3 as an indexed triangle mesh (uses octree sampling).
+func RenderMesh3(
+	s SDF3, //sdf3 to render
+	meshCells int, //number of cells on the longest axis. e.g 200
+) *Mesh3 {
+	resolution, _ := meshResolution(s, meshCells)
+	return marchingCubesOctree(s, resolution, 0, nil)
+}
+
+// RenderMesh3DualContour renders an SDF3 as an indexed triangle mesh (uses dual contouring).
+func RenderMesh3DualContour(
+	s SDF3, //sdf3 to render
+	meshCells int, //number of cells on the longest axis. e.g 200
+) *Mesh3 {
+	resolution, _ := meshResolution(s, meshCells)
+	return dualContouringOctree(s, resolution, 0, nil)
+}
+
+// RenderMesh3Adaptive renders an SDF3 as an indexed triangle mesh (uses adaptive dual contouring).
+// Regions where the surface is planar to within the tolerance get larger triangles.
+func RenderMesh3Adaptive(
+	s SDF3, //sdf3 to render
+	meshCells int, //number of cells on the longest axis. e.g 200
+	tolerance float64, //maximum deviation from planar for a large cell. e.g 0.01
+) *Mesh3 {
+	resolution, _ := meshResolution(s, meshCells)
+	return dualContouringOctree(s, resolution, tolerance, nil)
+}
+
+//-----------------------------------------------------------------------------
+
 // RenderSTL renders an SDF3 as an STL file (uses octree sampling).
 func RenderSTL(
 	s SDF3, //sdf3 to render
@@ -27,27 +73,18 @@ func RenderSTL(
 ) {
 
 	// work out the sampling resolution to use
-	bbSize := s.BoundingBox().Size()
-	resolution := bbSize.MaxComponent() / float64(meshCells)
-	cells := bbSize.DivScalar(resolution).ToV3i()
+	resolution, cells := meshResolution(s, meshCells)
 
 	fmt.Printf("rendering %s (%dx%dx%d, resolution %.2f)\n", path, cells[0], cells[1], cells[2], resolution)
 
+	// run marching cubes to generate the triangle mesh
+	m := marchingCubesOctree(s, resolution, 0, nil)
+
 	// write the triangles to an STL file
-	var wg sync.WaitGroup
-	output, err := WriteSTL(&wg, path)
+	err := SaveSTL(path, m.Triangles())
 	if err != nil {
 		fmt.Printf("%s", err)
-		return
 	}
-
-	// run marching cubes to generate the triangle mesh
-	marchingCubesOctree(s, resolution, output)
-
-	// stop the STL writer reading on the channel
-	close(output)
-	// wait for the file write to complete
-	wg.Wait()
 }
 
 // RenderSTLSlow renders an SDF3 as an STL file (uses uniform grid sampling).
@@ -69,8 +106,8 @@ func RenderSTLSlow(
 	fmt.Printf("rendering %s (%dx%dx%d)\n", path, cells[0], cells[1], cells[2])
 
 	// run marching cubes to generate the triangle mesh
-	m := marchingCubes(s, bb, meshInc)
-	err := SaveSTL(path, m)
+	m := marchingCubes(s, bb, meshInc, 0, nil)
+	err := SaveSTL(path, m.Triangles())
 	if err != nil {
 		fmt.Printf("%s", err)
 	}
@@ -78,6 +115,26 @@ func RenderSTLSlow(
 
 //-----------------------------------------------------------------------------
 
+// RenderLines2 renders an SDF2 as a set of line segments (uses quadtree sampling).
+func RenderLines2(
+	s SDF2, //sdf2 to render
+	meshCells int, //number of cells on the longest axis. e.g 200
+) []*Line {
+	bbSize := s.BoundingBox().Size()
+	resolution := bbSize.MaxComponent() / float64(meshCells)
+	return marchingSquaresQuadtreeLines(s, resolution, 0, nil)
+}
+
+// RenderContours renders an SDF2 as a tree of closed contours (uses quadtree sampling).
+func RenderContours(
+	s SDF2, //sdf2 to render
+	meshCells int, //number of cells on the longest axis. e.g 200
+) []*Contour {
+	bbSize := s.BoundingBox().Size()
+	resolution := bbSize.MaxComponent() / float64(meshCells)
+	return Contours(marchingSquaresQuadtreeLines(s, resolution, 0, nil), 1e-3*resolution)
+}
+
 // RenderDXF renders an SDF2 as a DXF file. (uses quadtree sampling)
 func RenderDXF(
 	s SDF2, //sdf2 to render
@@ -101,7 +158,7 @@ func RenderDXF(
 	}
 
 	// run marching squares to generate the line segments
-	marchingSquaresQuadtree(s, resolution, output)
+	marchingSquaresQuadtree(s, resolution, 0, nil, output)
 
 	// stop the DXF writer reading on the channel
 	close(output)
@@ -128,7 +185,7 @@ func RenderDXFSlow(
 	fmt.Printf("rendering %s (%dx%d)\n", path, cells[0], cells[1])
 
 	// run marching squares to generate the line segments
-	m := marchingSquares(s, bb, meshInc)
+	m := marchingSquares(s, bb, meshInc, 0, nil)
 	err := SaveDXF(path, m)
 	if err != nil {
 		fmt.Printf("%s", err)
@@ -153,19 +210,16 @@ func RenderSVG(
 
 	// write the line segments to an SVG file
 	var wg sync.WaitGroup
-	output, err := WriteSVG(&wg, path, lineStyle)
-	if err != nil {
-		return err
-	}
+	output, errc := writeSVG(&wg, NewSVG(path, lineStyle), (*SVG).Save)
 
 	// run marching squares to generate the line segments
-	marchingSquaresQuadtree(s, resolution, output)
+	marchingSquaresQuadtree(s, resolution, 0, nil, output)
 
 	// stop the SVG writer reading on the channel
 	close(output)
 	// wait for the file write to complete
 	wg.Wait()
-	return nil
+	return <-errc
 }
 
 // RenderSVGSlow renders an SDF2 as an SVG file. (uses uniform grid sampling)
@@ -188,8 +242,162 @@ func RenderSVGSlow(
 	fmt.Printf("rendering %s (%dx%d)\n", path, cells[0], cells[1])
 
 	// run marching squares to generate the line segments
-	m := marchingSquares(s, bb, meshInc)
+	m := marchingSquares(s, bb, meshInc, 0, nil)
 	return SaveSVG(path, lineStyle, m)
 }
 
 //-----------------------------------------------------------------------------
+// Render with options.
+
+// RenderAlgorithm selects the sampling algorithm for a render.
+type RenderAlgorithm int
+
+// Render algorithms.
+const (
+	RenderOctree      RenderAlgorithm = iota // marching cubes/squares, octree/quadtree sampling
+	RenderUniform                            // marching cubes/squares, uniform grid sampling
+	RenderDualContour                        // dual contouring (SDF3 only), adaptive if Tolerance > 0
+)
+
+// RenderParms defines the options for rendering an SDF.
+type RenderParms struct {
+	MeshCells  int             // number of cells on the longest axis. e.g 200
+	Resolution float64         // absolute cell size, overrides MeshCells if > 0
+	Padding    float64         // distance added to each side of the bounding box
+	Algorithm  RenderAlgorithm // sampling algorithm
+	Refine     int             // root finding iterations for marching cubes/squares vertices (0 == linear)
+	Tolerance  float64         // planarity tolerance for adaptive dual contouring
+	Logger     *log.Logger     // progress logger (nil == quiet)
+}
+
+// RenderStats are the statistics for a render.
+type RenderStats struct {
+	Triangles    int     // number of triangles (SDF3)
+	Lines        int     // number of line segments (SDF2)
+	Evaluations  uint64  // number of SDF evaluations
+	CacheHitRate float64 // fraction of distance cache lookups that were hits
+	lookups      uint64  // number of distance cache lookups
+	hits         uint64  // number of distance cache hits
+}
+
+// addCache adds distance cache statistics to the render statistics.
+func (rs *RenderStats) addCache(lookups, hits uint64) {
+	if rs == nil {
+		return
+	}
+	rs.lookups += lookups
+	rs.hits += hits
+	if rs.lookups > 0 {
+		rs.CacheHitRate = float64(rs.hits) / float64(rs.lookups)
+	}
+}
+
+// logf writes a message to the render logger.
+func (k *RenderParms) logf(format string, args ...interface{}) {
+	if k.Logger != nil {
+		k.Logger.Printf(format, args...)
+	}
+}
+
+// resolution returns the cell size for a bounding box.
+func (k *RenderParms) resolution(size float64) (float64, error) {
+	if size <= 0 {
+		return 0, errors.New("bad bounding box")
+	}
+	if k.Resolution > 0 {
+		return k.Resolution, nil
+	}
+	if k.MeshCells > 0 {
+		return size / float64(k.MeshCells), nil
+	}
+	return 0, errors.New("MeshCells or Resolution must be > 0")
+}
+
+// GenerateMesh3 renders an SDF3 as an indexed triangle mesh.
+func GenerateMesh3(s SDF3, k *RenderParms) (*Mesh3, *RenderStats, error) {
+	r, err := NewRenderer(k, 0, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.Mesh3(context.Background(), s)
+}
+
+// GenerateLines2 renders an SDF2 as a set of line segments.
+func GenerateLines2(s SDF2, k *RenderParms) ([]*Line, *RenderStats, error) {
+	r, err := NewRenderer(k, 0, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.Lines2(context.Background(), s)
+}
+
+// ToSTL renders an SDF3 as an STL file.
+func ToSTL(
+	s SDF3, // sdf3 to render
+	path string, // path to filename
+	k *RenderParms, // render options
+) (*RenderStats, error) {
+	r, err := NewRenderer(k, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.STL(context.Background(), s, path)
+}
+
+// ToDXF renders an SDF2 as a DXF file.
+func ToDXF(
+	s SDF2, // sdf2 to render
+	path string, // path to filename
+	k *RenderParms, // render options
+) (*RenderStats, error) {
+	r, err := NewRenderer(k, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.DXF(context.Background(), s, path)
+}
+
+// ToDXFContours renders an SDF2 as a DXF file of closed polylines.
+// Circular contours are written as circles.
+func ToDXFContours(
+	s SDF2, // sdf2 to render
+	path string, // path to filename
+	k *RenderParms, // render options
+) (*RenderStats, error) {
+	r, err := NewRenderer(k, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.DXFContours(context.Background(), s, path)
+}
+
+// ToSVG renders an SDF2 as an SVG file.
+func ToSVG(
+	s SDF2, // sdf2 to render
+	path string, // path to filename
+	lineStyle string, // SVG line style
+	k *RenderParms, // render options
+) (*RenderStats, error) {
+	r, err := NewRenderer(k, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.SVG(context.Background(), s, path, lineStyle)
+}
+
+// ToSVGContours renders an SDF2 as an SVG file of filled paths.
+// Use a nil style for black filled paths.
+func ToSVGContours(
+	s SDF2, // sdf2 to render
+	path string, // path to filename
+	style *SVGStyle, // fill and stroke style
+	k *RenderParms, // render options
+) (*RenderStats, error) {
+	r, err := NewRenderer(k, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.SVGContours(context.Background(), s, path, style)
+}
+
+//-----------------------------------------------------------------------------
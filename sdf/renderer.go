@@ -0,0 +1,373 @@
+//-----------------------------------------------------------------------------
+/*
+
+Renderer
+
+A renderer holds the render options, the number of evaluation workers and a
+progress callback. Each render takes a context so it can be cancelled.
+The evaluation workers are started for a render and stopped when it is done.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync/atomic"
+)
+
+//-----------------------------------------------------------------------------
+// Render state shared with the render algorithms.
+
+// renderState controls a single render.
+// A nil render state can't be cancelled, doesn't report progress and uses
+// runtime.NumCPU() evaluation workers.
+type renderState struct {
+	ctx      context.Context // cancellation context
+	workers  int             // number of evaluation workers (0 == runtime.NumCPU())
+	progress func(float64)   // progress callback (may be nil)
+	done     float64         // fraction of the render done
+	reported float64         // fraction of the render last reported
+	stats    RenderStats     // render statistics
+}
+
+// cancelled returns true if the render has been cancelled.
+func (r *renderState) cancelled() bool {
+	return r != nil && r.ctx != nil && r.ctx.Err() != nil
+}
+
+// numWorkers returns the number of evaluation workers.
+func (r *renderState) numWorkers() int {
+	if r == nil || r.workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return r.workers
+}
+
+// advance adds to the fraction of the render done.
+// Progress is reported in steps of 1%.
+func (r *renderState) advance(f float64) {
+	if r == nil || r.progress == nil {
+		return
+	}
+	r.done = Min(r.done+f, 1)
+	if r.done-r.reported >= 0.01 {
+		r.reported = r.done
+		r.progress(r.done)
+	}
+}
+
+// finish reports the completion of the render.
+func (r *renderState) finish() {
+	if r.progress != nil && r.reported < 1 {
+		r.reported = 1
+		r.progress(1)
+	}
+}
+
+// addCache adds distance cache statistics to the render statistics.
+func (r *renderState) addCache(lookups, hits uint64) {
+	if r != nil {
+		r.stats.addCache(lookups, hits)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Count SDF evaluations.
+
+// countSDF3 counts the evaluations of an SDF3 and pads its bounding box.
+type countSDF3 struct {
+	s  SDF3
+	bb Box3
+	n  uint64
+}
+
+func (s *countSDF3) Evaluate(p V3) float64 {
+	atomic.AddUint64(&s.n, 1)
+	return s.s.Evaluate(p)
+}
+
+func (s *countSDF3) BoundingBox() Box3 {
+	return s.bb
+}
+
+// countSDF2 counts the evaluations of an SDF2 and pads its bounding box.
+type countSDF2 struct {
+	s  SDF2
+	bb Box2
+	n  uint64
+}
+
+func (s *countSDF2) Evaluate(p V2) float64 {
+	atomic.AddUint64(&s.n, 1)
+	return s.s.Evaluate(p)
+}
+
+func (s *countSDF2) BoundingBox() Box2 {
+	return s.bb
+}
+
+//-----------------------------------------------------------------------------
+
+// Renderer renders SDFs with a set of render options.
+// A nil context is the same as context.Background().
+type Renderer struct {
+	k        RenderParms
+	workers  int
+	progress func(done float64)
+}
+
+// NewRenderer returns a renderer.
+func NewRenderer(
+	k *RenderParms, // render options
+	workers int, // number of evaluation workers (0 == runtime.NumCPU())
+	progress func(done float64), // called with the fraction of the render done (may be nil)
+) (*Renderer, error) {
+	if k == nil {
+		return nil, errors.New("no render parameters")
+	}
+	if workers < 0 {
+		return nil, errors.New("workers < 0")
+	}
+	return &Renderer{
+		k:        *k,
+		workers:  workers,
+		progress: progress,
+	}, nil
+}
+
+// newState returns the render state for a render.
+func (r *Renderer) newState(ctx context.Context) *renderState {
+	return &renderState{
+		ctx:      ctx,
+		workers:  r.workers,
+		progress: r.progress,
+	}
+}
+
+// Mesh3 renders an SDF3 as an indexed triangle mesh.
+// It returns the context error if the render is cancelled.
+func (r *Renderer) Mesh3(ctx context.Context, s SDF3) (*Mesh3, *RenderStats, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	k := &r.k
+	bb := s.BoundingBox()
+	bb = Box3{bb.Min.SubScalar(k.Padding), bb.Max.AddScalar(k.Padding)}
+	resolution, err := k.resolution(bb.Size().MaxComponent())
+	if err != nil {
+		return nil, nil, err
+	}
+	cs := &countSDF3{s: s, bb: bb}
+	rs := r.newState(ctx)
+	cells := bb.Size().DivScalar(resolution).ToV3i()
+	k.logf("rendering (%dx%dx%d, resolution %.2f)\n", cells[0], cells[1], cells[2], resolution)
+
+	var m *Mesh3
+	switch k.Algorithm {
+	case RenderOctree:
+		m = marchingCubesOctree(cs, resolution, k.Refine, rs)
+	case RenderUniform:
+		size := bb.Size().DivScalar(resolution).Ceil().AddScalar(1).MulScalar(resolution)
+		m = marchingCubes(cs, NewBox3(bb.Center(), size), resolution, k.Refine, rs)
+	case RenderDualContour:
+		m = dualContouringOctree(cs, resolution, k.Tolerance, rs)
+	default:
+		return nil, nil, fmt.Errorf("unknown render algorithm %d", k.Algorithm)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	rs.finish()
+
+	stats := &rs.stats
+	stats.Triangles = len(m.F)
+	stats.Evaluations = cs.n
+	k.logf("%d triangles, %d evaluations, %.1f%% cache hits\n", stats.Triangles, stats.Evaluations, 100*stats.CacheHitRate)
+	return m, stats, nil
+}
+
+// Lines2 renders an SDF2 as a set of line segments.
+// It returns the context error if the render is cancelled.
+func (r *Renderer) Lines2(ctx context.Context, s SDF2) ([]*Line, *RenderStats, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	k := &r.k
+	bb := s.BoundingBox()
+	bb = Box2{bb.Min.SubScalar(k.Padding), bb.Max.AddScalar(k.Padding)}
+	resolution, err := k.resolution(bb.Size().MaxComponent())
+	if err != nil {
+		return nil, nil, err
+	}
+	cs := &countSDF2{s: s, bb: bb}
+	rs := r.newState(ctx)
+	cells := bb.Size().DivScalar(resolution).ToV2i()
+	k.logf("rendering (%dx%d, resolution %.2f)\n", cells[0], cells[1], resolution)
+
+	var lines []*Line
+	switch k.Algorithm {
+	case RenderOctree:
+		lines = marchingSquaresQuadtreeLines(cs, resolution, k.Refine, rs)
+	case RenderUniform:
+		size := bb.Size().DivScalar(resolution).Ceil().AddScalar(1).MulScalar(resolution)
+		lines = marchingSquares(cs, NewBox2(bb.Center(), size), resolution, k.Refine, rs)
+	default:
+		return nil, nil, fmt.Errorf("unsupported render algorithm %d", k.Algorithm)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	rs.finish()
+
+	stats := &rs.stats
+	stats.Lines = len(lines)
+	stats.Evaluations = cs.n
+	k.logf("%d lines, %d evaluations, %.1f%% cache hits\n", stats.Lines, stats.Evaluations, 100*stats.CacheHitRate)
+	return lines, stats, nil
+}
+
+// Contours renders an SDF2 as a tree of closed contours.
+func (r *Renderer) Contours(ctx context.Context, s SDF2) ([]*Contour, *RenderStats, error) {
+	lines, rs, err := r.Lines2(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Contours(lines, 1e-3*r.resolution2(s)), rs, nil
+}
+
+// resolution2 returns the sampling resolution for an SDF2.
+func (r *Renderer) resolution2(s SDF2) float64 {
+	bb := s.BoundingBox()
+	resolution, _ := r.k.resolution(bb.Size().MaxComponent() + 2*r.k.Padding)
+	return resolution
+}
+
+// contoursDXF renders an SDF2 as a dxf drawing object of closed polylines.
+func (r *Renderer) contoursDXF(ctx context.Context, s SDF2, path string) (*DXF, *RenderStats, error) {
+	contours, rs, err := r.Contours(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	d := NewDXF(path)
+	d.Contours(contours, 0.05*r.resolution2(s))
+	return d, rs, nil
+}
+
+// EncodeSTL renders an SDF3 in STL format to a writer.
+func (r *Renderer) EncodeSTL(ctx context.Context, s SDF3, w io.Writer) (*RenderStats, error) {
+	m, rs, err := r.Mesh3(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	return rs, EncodeSTL(w, m.Triangles())
+}
+
+// EncodeDXF renders an SDF2 in DXF format to a writer.
+func (r *Renderer) EncodeDXF(ctx context.Context, s SDF2, w io.Writer) (*RenderStats, error) {
+	lines, rs, err := r.Lines2(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	return rs, EncodeDXF(w, lines)
+}
+
+// contoursSVG renders an SDF2 as an SVG renderer with a layer of filled paths.
+func (r *Renderer) contoursSVG(ctx context.Context, s SDF2, path string, k *SVGStyle) (*SVG, *RenderStats, error) {
+	contours, rs, err := r.Contours(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	d := NewSVG(path, "")
+	d.Layer("Layer 1", k)
+	d.Contours(contours)
+	return d, rs, nil
+}
+
+// EncodeDXFContours renders an SDF2 in DXF format to a writer as closed
+// polylines. Circular contours are written as circles.
+func (r *Renderer) EncodeDXFContours(ctx context.Context, s SDF2, w io.Writer) (*RenderStats, error) {
+	d, rs, err := r.contoursDXF(ctx, s, "")
+	if err != nil {
+		return nil, err
+	}
+	return rs, d.Encode(w)
+}
+
+// EncodeSVG renders an SDF2 in SVG format to a writer.
+func (r *Renderer) EncodeSVG(ctx context.Context, s SDF2, w io.Writer, lineStyle string) (*RenderStats, error) {
+	lines, rs, err := r.Lines2(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	return rs, EncodeSVG(w, lineStyle, lines)
+}
+
+// EncodeSVGContours renders an SDF2 in SVG format to a writer as filled
+// paths. Use a nil style for black filled paths.
+func (r *Renderer) EncodeSVGContours(ctx context.Context, s SDF2, w io.Writer, k *SVGStyle) (*RenderStats, error) {
+	d, rs, err := r.contoursSVG(ctx, s, "", k)
+	if err != nil {
+		return nil, err
+	}
+	return rs, d.Encode(w)
+}
+
+// STL renders an SDF3 as an STL file.
+func (r *Renderer) STL(ctx context.Context, s SDF3, path string) (*RenderStats, error) {
+	m, rs, err := r.Mesh3(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	r.k.logf("writing %s\n", path)
+	return rs, SaveSTL(path, m.Triangles())
+}
+
+// DXF renders an SDF2 as a DXF file.
+func (r *Renderer) DXF(ctx context.Context, s SDF2, path string) (*RenderStats, error) {
+	lines, rs, err := r.Lines2(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	r.k.logf("writing %s\n", path)
+	return rs, SaveDXF(path, lines)
+}
+
+// DXFContours renders an SDF2 as a DXF file of closed polylines.
+// Circular contours are written as circles.
+func (r *Renderer) DXFContours(ctx context.Context, s SDF2, path string) (*RenderStats, error) {
+	d, rs, err := r.contoursDXF(ctx, s, path)
+	if err != nil {
+		return nil, err
+	}
+	r.k.logf("writing %s\n", path)
+	return rs, d.Save()
+}
+
+// SVG renders an SDF2 as an SVG file.
+func (r *Renderer) SVG(ctx context.Context, s SDF2, path, lineStyle string) (*RenderStats, error) {
+	lines, rs, err := r.Lines2(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	r.k.logf("writing %s\n", path)
+	return rs, SaveSVG(path, lineStyle, lines)
+}
+
+// SVGContours renders an SDF2 as an SVG file of filled paths.
+// Use a nil style for black filled paths.
+func (r *Renderer) SVGContours(ctx context.Context, s SDF2, path string, k *SVGStyle) (*RenderStats, error) {
+	d, rs, err := r.contoursSVG(ctx, s, path, k)
+	if err != nil {
+		return nil, err
+	}
+	r.k.logf("writing %s\n", path)
+	return rs, d.Save()
+}
+
+//-----------------------------------------------------------------------------
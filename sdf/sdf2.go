@@ -63,6 +63,20 @@ func sdfBox2d(p, s V2) float64 {
 	return d.X
 }
 
+// sdfBox2dGradient returns the gradient of sdfBox2d.
+func sdfBox2dGradient(p, s V2) V2 {
+	d := p.Abs().Sub(s)
+	var g V2
+	if d.X > 0 || d.Y > 0 {
+		g = d.Max(V2{0, 0}).Normalize()
+	} else if d.Y > d.X {
+		g = V2{0, 1}
+	} else {
+		g = V2{1, 0}
+	}
+	return V2{math.Copysign(g.X, p.X), math.Copysign(g.Y, p.Y)}
+}
+
 //-----------------------------------------------------------------------------
 // 2D Circle
 
@@ -86,6 +100,14 @@ func (s *CircleSDF2) Evaluate(p V2) float64 {
 	return p.Length() - s.radius
 }
 
+// Gradient returns the gradient of the distance to a 2d circle.
+func (s *CircleSDF2) Gradient(p V2) V2 {
+	if p.Length2() == 0 {
+		return V2{}
+	}
+	return p.Normalize()
+}
+
 // BoundingBox returns the bounding box of a 2d circle.
 func (s *CircleSDF2) BoundingBox() Box2 {
 	return s.bb
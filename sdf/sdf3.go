@@ -56,6 +56,22 @@ func sdfBox3d(p, s V3) float64 {
 	return d.MaxComponent()
 }
 
+// sdfBox3dGradient returns the gradient of sdfBox3d.
+func sdfBox3dGradient(p, s V3) V3 {
+	d := p.Abs().Sub(s)
+	var g V3
+	if d.X > 0 || d.Y > 0 || d.Z > 0 {
+		g = d.Max(V3{0, 0, 0}).Normalize()
+	} else if d.X >= d.Y && d.X >= d.Z {
+		g = V3{1, 0, 0}
+	} else if d.Y >= d.Z {
+		g = V3{0, 1, 0}
+	} else {
+		g = V3{0, 0, 1}
+	}
+	return V3{math.Copysign(g.X, p.X), math.Copysign(g.Y, p.Y), math.Copysign(g.Z, p.Z)}
+}
+
 //-----------------------------------------------------------------------------
 
 // SorSDF3 solid of revolution, SDF2 to SDF3.
@@ -369,6 +385,11 @@ func (s *BoxSDF3) Evaluate(p V3) float64 {
 	return sdfBox3d(p, s.size) - s.round
 }
 
+// Gradient returns the gradient of the distance to a 3d box.
+func (s *BoxSDF3) Gradient(p V3) V3 {
+	return sdfBox3dGradient(p, s.size)
+}
+
 // BoundingBox returns the bounding box for a 3d box.
 func (s *BoxSDF3) BoundingBox() Box3 {
 	return s.bb
@@ -397,6 +418,14 @@ func (s *SphereSDF3) Evaluate(p V3) float64 {
 	return p.Length() - s.radius
 }
 
+// Gradient returns the gradient of the distance to a sphere.
+func (s *SphereSDF3) Gradient(p V3) V3 {
+	if p.Length2() == 0 {
+		return V3{}
+	}
+	return p.Normalize()
+}
+
 // BoundingBox returns the bounding box for a sphere.
 func (s *SphereSDF3) BoundingBox() Box3 {
 	return s.bb
@@ -435,6 +464,18 @@ func (s *CylinderSDF3) Evaluate(p V3) float64 {
 	return d - s.round
 }
 
+// Gradient returns the gradient of the distance to a cylinder.
+func (s *CylinderSDF3) Gradient(p V3) V3 {
+	r := V2{p.X, p.Y}
+	g := sdfBox2dGradient(V2{r.Length(), p.Z}, V2{s.radius, s.height})
+	if r.Length2() == 0 {
+		// on the axis the radial direction is arbitrary
+		return V3{g.X, 0, g.Y}
+	}
+	r = r.Normalize().MulScalar(g.X)
+	return V3{r.X, r.Y, g.Y}
+}
+
 // BoundingBox returns the bounding box for a cylinder.
 func (s *CylinderSDF3) BoundingBox() Box3 {
 	return s.bb
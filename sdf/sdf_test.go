@@ -5,8 +5,21 @@
 package sdf
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -722,3 +735,1386 @@ func Test_Box2_Distances(t *testing.T) {
 }
 
 //-----------------------------------------------------------------------------
+
+func Test_DualContouring(t *testing.T) {
+	s := Box3D(V3{10, 20, 30}, 0)
+	m := dualContouringOctree(s, 1.0, 0, nil)
+	if len(m.F) == 0 {
+		t.Fatal("FAIL")
+	}
+	// all vertices are on the surface, and the box corners are reproduced
+	corner := V3{5, 10, 15}
+	cornerDist := 1e10
+	for _, v := range m.V {
+		if Abs(s.Evaluate(v)) > 1e-6 {
+			t.Logf("vertex %v is off the surface\n", v)
+			t.Error("FAIL")
+			return
+		}
+		cornerDist = Min(cornerDist, v.Sub(corner).Length())
+	}
+	if cornerDist > 1e-6 {
+		t.Logf("nearest corner vertex %f\n", cornerDist)
+		t.Error("FAIL")
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func Test_Mesh3(t *testing.T) {
+	objects := []SDF3{
+		Sphere3D(10),
+		Box3D(V3{10, 20, 30}, 2),
+		Difference3D(Box3D(V3{30, 30, 10}, 1), Cylinder3D(20, 8, 0)),
+	}
+	for _, s := range objects {
+		bb := s.BoundingBox().ScaleAboutCenter(1.1)
+		meshes := []*Mesh3{
+			marchingCubesOctree(s, 0.7, 0, nil),
+			marchingCubes(s, bb, 0.7, 0, nil),
+		}
+		for _, m := range meshes {
+			if len(m.F) == 0 || !m.Closed() {
+				t.Error("FAIL")
+			}
+			// welding a triangle soup gives the same mesh
+			w := NewMesh3(m.Triangles(), 0)
+			if len(w.V) > len(m.V) || len(w.F) != len(m.F) || !w.Closed() {
+				t.Error("FAIL")
+			}
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func Test_AdaptiveMesh(t *testing.T) {
+	objects := []struct {
+		s      SDF3
+		volume float64
+	}{
+		{Box3D(V3{10, 20, 30}, 0), 6000},
+		{Sphere3D(10), 4.0 / 3.0 * Pi * 1000},
+	}
+	for _, v := range objects {
+		m0 := dualContouringOctree(v.s, 0.4, 0, nil)
+		m1 := dualContouringOctree(v.s, 0.4, 0.02, nil)
+		if !m0.Closed() || !m1.Closed() {
+			t.Error("FAIL")
+		}
+		// the flat regions have larger triangles
+		if len(m1.F) >= len(m0.F) {
+			t.Error("FAIL")
+		}
+		// the volume is unchanged
+		var volume float64
+		for _, f := range m1.F {
+			volume += m1.V[f[0]].Dot(m1.V[f[1]].Cross(m1.V[f[2]])) / 6
+		}
+		if Abs(volume-v.volume)/v.volume > 0.005 {
+			t.Logf("expected %f, actual %f\n", v.volume, volume)
+			t.Error("FAIL")
+		}
+	}
+}
+
+func Test_Decimate(t *testing.T) {
+	objects := []SDF3{
+		Sphere3D(10),
+		Box3D(V3{10, 20, 30}, 2),
+	}
+	for _, s := range objects {
+		m := marchingCubesOctree(s, 0.7, 0, nil)
+		// target triangle count
+		d := m.Decimate(&DecimateParms{Triangles: len(m.F) / 4})
+		if len(d.F) > len(m.F)/4 || !d.Closed() {
+			t.Error("FAIL")
+		}
+		// bounded error against the source SDF3
+		d = m.Decimate(&DecimateParms{MaxError: 0.05, SDF: s})
+		if len(d.F) >= len(m.F) || !d.Closed() {
+			t.Error("FAIL")
+		}
+		for _, v := range d.V {
+			if Abs(s.Evaluate(v)) > 0.05 {
+				t.Error("FAIL")
+				break
+			}
+		}
+	}
+	// the error limit doesn't depend on the scale of the model
+	m := marchingCubesOctree(Sphere3D(10), 0.5, 0, nil)
+	count := func(k float64) int {
+		ms := &Mesh3{F: m.F}
+		for _, v := range m.V {
+			ms.V = append(ms.V, v.MulScalar(k))
+		}
+		return len(ms.Decimate(&DecimateParms{MaxError: 0.02 * k}).F)
+	}
+	n := count(1)
+	if n >= len(m.F) || count(4) != n || Abs(float64(count(10)-n)) > 0.05*float64(n) {
+		t.Error("FAIL")
+	}
+	// nil parameters collapse every valid edge
+	if d := m.Decimate(nil); len(d.F) >= n || !d.Closed() {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Refine(t *testing.T) {
+	// scaling gives a field that is not a true distance
+	s3 := Transform3D(Sphere3D(5), Scale3d(V3{1, 1, 3}))
+	for _, refine := range []int{0, 10} {
+		m := marchingCubesOctree(s3, 0.5, refine, nil)
+		var err float64
+		for _, v := range m.V {
+			err = Max(err, Abs(V3{v.X, v.Y, v.Z / 3}.Length()-5))
+		}
+		if !m.Closed() || (refine == 0 && err < 1e-3) || (refine > 0 && err > 1e-6) {
+			t.Logf("refine %d, error %e\n", refine, err)
+			t.Error("FAIL")
+		}
+	}
+	s2 := Transform2D(Circle2D(5), Scale2d(V2{1, 3}))
+	bb := s2.BoundingBox().ScaleAboutCenter(1.1)
+	for _, refine := range []int{0, 10} {
+		var err float64
+		for _, l := range marchingSquares(s2, bb, 0.5, refine, nil) {
+			for _, v := range l {
+				err = Max(err, Abs(V2{v.X, v.Y / 3}.Length()-5))
+			}
+		}
+		if (refine == 0 && err < 1e-3) || (refine > 0 && err > 1e-6) {
+			t.Logf("refine %d, error %e\n", refine, err)
+			t.Error("FAIL")
+		}
+	}
+}
+
+func Test_RenderParms(t *testing.T) {
+	s3 := Sphere3D(10)
+	for _, a := range []RenderAlgorithm{RenderOctree, RenderUniform, RenderDualContour} {
+		m, rs, err := GenerateMesh3(s3, &RenderParms{MeshCells: 40, Padding: 1, Algorithm: a})
+		if err != nil || !m.Closed() || rs.Triangles != len(m.F) || rs.Evaluations == 0 {
+			t.Error("FAIL")
+		}
+		if a != RenderUniform && (rs.CacheHitRate <= 0 || rs.CacheHitRate >= 1) {
+			t.Error("FAIL")
+		}
+	}
+	s2 := Circle2D(10)
+	lines, rs, err := GenerateLines2(s2, &RenderParms{Resolution: 0.5})
+	if err != nil || len(lines) == 0 || rs.Lines != len(lines) || rs.Evaluations == 0 {
+		t.Error("FAIL")
+	}
+	// errors are returned
+	if _, err := ToSTL(s3, "/nonexistent/test.stl", &RenderParms{MeshCells: 10}); err == nil {
+		t.Error("FAIL")
+	}
+	if _, err := ToDXF(s2, "test.dxf", &RenderParms{}); err == nil {
+		t.Error("FAIL")
+	}
+	if _, err := ToSVG(s2, "test.svg", "", &RenderParms{MeshCells: 10, Algorithm: RenderDualContour}); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Renderer(t *testing.T) {
+	s := Sphere3D(10)
+	for _, a := range []RenderAlgorithm{RenderOctree, RenderUniform, RenderDualContour} {
+		// progress is reported up to completion
+		var done []float64
+		r, err := NewRenderer(&RenderParms{MeshCells: 40, Algorithm: a}, 2, func(x float64) {
+			done = append(done, x)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, _, err := r.Mesh3(context.Background(), s)
+		if err != nil || !m.Closed() || len(done) == 0 || done[len(done)-1] != 1 {
+			t.Error("FAIL")
+		}
+		for i := 1; i < len(done); i++ {
+			if done[i] < done[i-1] {
+				t.Error("FAIL")
+			}
+		}
+		// a cancelled render returns the context error
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, _, err := r.Mesh3(ctx, s); err != context.Canceled {
+			t.Error("FAIL")
+		}
+	}
+	if _, err := NewRenderer(&RenderParms{MeshCells: 40}, -1, nil); err == nil {
+		t.Error("FAIL")
+	}
+	// a nil context is a background context
+	r, _ := NewRenderer(&RenderParms{MeshCells: 20}, 0, nil)
+	if _, _, err := r.Mesh3(nil, s); err != nil {
+		t.Error("FAIL")
+	}
+	if _, _, err := r.Lines2(nil, Circle2D(10)); err != nil {
+		t.Error("FAIL")
+	}
+}
+
+// failWriter is a writer that always fails.
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func Test_Writers(t *testing.T) {
+	m := RenderMesh3(Sphere3D(10), 20)
+	triangles := m.Triangles()
+	// the buffered stream and the encoder give the same data
+	var b0, b1 bytes.Buffer
+	if err := EncodeSTL(&b0, triangles); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	c, errc := WriteSTLTo(&wg, &b1)
+	for _, x := range triangles {
+		c <- x
+	}
+	close(c)
+	wg.Wait()
+	if err := <-errc; err != nil || b0.Len() != 84+50*len(triangles) || !bytes.Equal(b0.Bytes(), b1.Bytes()) {
+		t.Error("FAIL")
+	}
+	// write errors are returned
+	c, errc = WriteSTLTo(&wg, failWriter{})
+	c <- triangles[0]
+	close(c)
+	wg.Wait()
+	if <-errc == nil {
+		t.Error("FAIL")
+	}
+	lc, errc := WriteDXFTo(&wg, failWriter{})
+	close(lc)
+	wg.Wait()
+	if <-errc == nil {
+		t.Error("FAIL")
+	}
+	lc, errc = WriteSVGTo(&wg, failWriter{}, "")
+	close(lc)
+	wg.Wait()
+	if <-errc == nil {
+		t.Error("FAIL")
+	}
+	// 2d formats
+	lines := RenderLines2(Circle2D(10), 20)
+	var b2, b3 bytes.Buffer
+	if err := EncodeDXF(&b2, lines); err != nil || !bytes.Contains(b2.Bytes(), []byte("LINE")) {
+		t.Error("FAIL")
+	}
+	if err := EncodeSVG(&b3, "", lines); err != nil || !bytes.Contains(b3.Bytes(), []byte("<svg")) {
+		t.Error("FAIL")
+	}
+	// renderer
+	r, _ := NewRenderer(&RenderParms{MeshCells: 20}, 0, nil)
+	var b4 bytes.Buffer
+	rs, err := r.EncodeSTL(context.Background(), Sphere3D(10), &b4)
+	if err != nil || b4.Len() != 84+50*rs.Triangles {
+		t.Error("FAIL")
+	}
+}
+
+func Test_MeshImport(t *testing.T) {
+	// binary STL round trip
+	m := RenderMesh3(Sphere3D(10), 30)
+	var b bytes.Buffer
+	if err := EncodeSTL(&b, m.Triangles()); err != nil {
+		t.Fatal(err)
+	}
+	triangles, err := DecodeSTL(&b)
+	if err != nil || len(triangles) != len(m.F) {
+		t.Error("FAIL")
+	}
+	// ASCII STL
+	ascii := `solid cube
+facet normal 0 0 1
+  outer loop
+    vertex 0 0 1
+    vertex 1 0 1
+    vertex 1 1 1
+  endloop
+endfacet
+endsolid cube
+`
+	triangles, err = DecodeSTL(strings.NewReader(ascii))
+	if err != nil || len(triangles) != 1 || !triangles[0].V[2].Equals(V3{1, 1, 1}, 0) {
+		t.Error("FAIL")
+	}
+	// OBJ cube with quad faces and negative indices
+	obj := `v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+v 0 0 1
+v 1 0 1
+v 1 1 1
+v 0 1 1
+f 1 4 3 2
+f 5 6 7 8
+f 1 2 6 5
+f 2/1 3/1 7/1 6/1
+f 3//1 4//1 8//1 7//1
+f -4 -1 -5 -8
+`
+	triangles, err = DecodeOBJ(strings.NewReader(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cube := NewMesh3(triangles, tolerance)
+	if len(cube.V) != 8 || len(cube.F) != 12 || !cube.Closed() {
+		t.Error("FAIL")
+	}
+	// mesh SDF3
+	s, err := Mesh3D(triangles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := []struct {
+		p V3
+		d float64
+	}{
+		{V3{0.5, 0.5, 0.5}, -0.5},
+		{V3{0.5, 0.5, 0.9}, -0.1},
+		{V3{0.5, 0.5, 2}, 1},
+		{V3{2, 2, 2}, math.Sqrt(3)},
+		{V3{-1, 0.5, 0.5}, 1},
+	}
+	for _, v := range test {
+		if d := s.Evaluate(v.p); Abs(d-v.d) > tolerance {
+			t.Logf("%v expected %f, actual %f\n", v.p, v.d, d)
+			t.Error("FAIL")
+		}
+	}
+	// the imported sphere works with other SDF3s
+	s, err = Mesh3D(m.Triangles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = Difference3D(s, Box3D(V3{5, 5, 30}, 0))
+	if d := s.Evaluate(V3{0, 0, 5}); d < 0 {
+		t.Error("FAIL")
+	}
+	if d := s.Evaluate(V3{0, 5, 0}); d > -2 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_ThreeMF(t *testing.T) {
+	box := PanelBox3D(&PanelBoxParms{
+		Size:       V3{50.0, 40.0, 60.0},
+		Wall:       2.5,
+		Panel:      3.0,
+		Rounding:   5.0,
+		FrontInset: 2.0,
+		BackInset:  2.0,
+		Hole:       3.4,
+		SideTabs:   "TbtbT",
+	})
+	d, err := NewThreeMF("test.3mf", "inch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Metadata("Title", "Panel Box <test>")
+	// the panel is used for the front and back
+	parts := []SDF3{box[1], box[2], box[0], box[0]}
+	names := []string{"top", "bottom", "front", "back"}
+	for i, s := range parts {
+		var c color.Color
+		if i%2 == 0 {
+			c = color.RGBA{0xff, 0, 0, 0xff}
+		}
+		if err := d.AddObject(names[i], RenderMesh3(s, 30), Translate3d(V3{float64(i) * 70, 0, 0}), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var b bytes.Buffer
+	if err := d.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	// read the model back
+	z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var model struct {
+		Unit     string `xml:"unit,attr"`
+		Metadata []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"metadata"`
+		Objects []struct {
+			Name      string `xml:"name,attr"`
+			Vertices  []V3   `xml:"mesh>vertices>vertex"`
+			Triangles []struct {
+				V1 int `xml:"v1,attr"`
+			} `xml:"mesh>triangles>triangle"`
+		} `xml:"resources>object"`
+		Items []struct {
+			Transform string `xml:"transform,attr"`
+		} `xml:"build>item"`
+	}
+	found := 0
+	for _, f := range z.File {
+		switch f.Name {
+		case "[Content_Types].xml", "_rels/.rels":
+			found++
+		case "3D/3dmodel.model":
+			found++
+			r, _ := f.Open()
+			if err := xml.NewDecoder(r).Decode(&model); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if found != 3 || model.Unit != "inch" || len(model.Objects) != 4 || len(model.Items) != 4 {
+		t.Error("FAIL")
+	}
+	if model.Objects[2].Name != "front" || len(model.Objects[2].Triangles) == 0 {
+		t.Error("FAIL")
+	}
+	if model.Items[0].Transform != "" || model.Items[1].Transform != "1 0 0 0 1 0 0 0 1 70 0 0" {
+		t.Error("FAIL")
+	}
+	if len(model.Metadata) != 2 || model.Metadata[1].Value != "Panel Box <test>" {
+		t.Error("FAIL")
+	}
+	if _, err := NewThreeMF("test.3mf", "furlong"); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_PLYOBJ(t *testing.T) {
+	s := Sphere3D(10)
+	m := RenderMesh3(s, 30)
+	normals := m.VertexNormals(s)
+	for i, v := range m.V {
+		if normals[i].Sub(v.Normalize()).Length() > 1e-3 {
+			t.Error("FAIL")
+			break
+		}
+	}
+	// the face normals should be close to the gradient normals
+	fn := m.VertexNormals(nil)
+	for i := range m.V {
+		if fn[i].Dot(normals[i]) < 0.95 {
+			t.Error("FAIL")
+			break
+		}
+	}
+
+	// PLY
+	colors := make([]color.Color, len(m.V))
+	scalar := make([]float64, len(m.V))
+	for i, v := range m.V {
+		colors[i] = color.Gray{uint8(128 + 12*v.Z)}
+		scalar[i] = v.Z
+	}
+	var b bytes.Buffer
+	err := EncodePLY(&b, m, &PLYParms{Normals: normals, Colors: colors, Scalar: scalar, ScalarName: "height"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(b.Bytes(), []byte("end_header\n"))
+	if i < 0 {
+		t.Fatal("FAIL")
+	}
+	header := string(b.Bytes()[:i])
+	if !strings.Contains(header, "format binary_little_endian 1.0") ||
+		!strings.Contains(header, "property float nx") ||
+		!strings.Contains(header, "property uchar red") ||
+		!strings.Contains(header, "property float height") {
+		t.Error("FAIL")
+	}
+	// 3 floats + 3 floats + 4 bytes + 1 float per vertex, 1 byte + 3 ints per face
+	size := i + len("end_header\n") + len(m.V)*(12+12+4+4) + len(m.F)*13
+	if b.Len() != size {
+		t.Error("FAIL")
+	}
+	if EncodePLY(&b, m, &PLYParms{Scalar: scalar[1:]}) == nil {
+		t.Error("FAIL")
+	}
+
+	// OBJ
+	b.Reset()
+	if err := EncodeOBJ(&b, m, normals); err != nil {
+		t.Fatal(err)
+	}
+	triangles, err := DecodeOBJ(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2 := NewMesh3(triangles, tolerance)
+	if len(m2.V) != len(m.V) || len(m2.F) != len(m.F) || !m2.Closed() {
+		t.Error("FAIL")
+	}
+}
+
+func Test_GLTF(t *testing.T) {
+	d, err := NewGLTF("test.glb", "mm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s0 := Sphere3D(10)
+	m0 := RenderMesh3(s0, 30)
+	s1 := Box3D(V3{10, 20, 30}, 2)
+	m1 := RenderMesh3(s1, 30)
+	if err := d.AddObject("sphere", m0, m0.VertexNormals(s0), Identity3d(), color.RGBA{0xff, 0, 0, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddObject("box", m1, nil, Translate3d(V3{30, 0, 0}), nil); err != nil {
+		t.Fatal(err)
+	}
+	if d.AddObject("bad", m1, m0.V, Identity3d(), nil) == nil {
+		t.Error("FAIL")
+	}
+	var b bytes.Buffer
+	if err := d.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	// check the GLB header and chunks
+	buf := b.Bytes()
+	if string(buf[:4]) != "glTF" || binary.LittleEndian.Uint32(buf[8:]) != uint32(len(buf)) {
+		t.Fatal("FAIL")
+	}
+	n := int(binary.LittleEndian.Uint32(buf[12:]))
+	if string(buf[16:20]) != "JSON" || n%4 != 0 {
+		t.Fatal("FAIL")
+	}
+	var doc gltfDocument
+	if err := json.Unmarshal(buf[20:20+n], &doc); err != nil {
+		t.Fatal(err)
+	}
+	bin := buf[20+n:]
+	if string(bin[4:8]) != "BIN\x00" || int(binary.LittleEndian.Uint32(bin)) < doc.Buffers[0].ByteLength {
+		t.Error("FAIL")
+	}
+	if len(doc.Nodes) != 3 || len(doc.Meshes) != 2 || len(doc.Accessors) != 6 {
+		t.Fatal("FAIL")
+	}
+	if doc.Nodes[1].Name != "sphere" || doc.Nodes[1].Matrix != nil || doc.Nodes[2].Matrix[12] != 30 {
+		t.Error("FAIL")
+	}
+	// the root node scales mm to m and turns z up into y up (column major)
+	root := []float64{1e-3, 0, 0, 0, 0, 0, -1e-3, 0, 0, 1e-3, 0, 0, 0, 0, 0, 1}
+	if doc.Nodes[0].Name != "Scene" || len(doc.Nodes[0].Matrix) != 16 {
+		t.Fatal("FAIL")
+	}
+	for i, x := range root {
+		if math.Abs(doc.Nodes[0].Matrix[i]-x) > 1e-12 {
+			t.Error("FAIL")
+		}
+	}
+	if doc.Accessors[0].Count != len(m0.V) || doc.Accessors[2].Count != 3*len(m0.F) {
+		t.Error("FAIL")
+	}
+	if doc.Materials[0].PBRMetallicRoughness.BaseColorFactor[0] != 1 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_MSLA(t *testing.T) {
+	s := Cylinder3D(5, 10, 0)
+	k := &MSLAParms{
+		LayerHeight:    0.05,
+		Pixels:         V2i{200, 100},
+		BuildArea:      V2{40, 20},
+		Antialias:      true,
+		Exposure:       2,
+		BottomExposure: 30,
+		BottomLayers:   4,
+	}
+	var b bytes.Buffer
+	if err := EncodeMSLA(&b, s, "test", k); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(z.File) != 100+2 {
+		t.Fatal("FAIL")
+	}
+	var config string
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch f.Name {
+		case "config.ini":
+			var cb bytes.Buffer
+			cb.ReadFrom(r)
+			config = cb.String()
+		case "test00050.png":
+			img, err := png.Decode(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the center is exposed, the corners are not
+			g := img.(*image.Gray)
+			if g.GrayAt(100, 50).Y != 255 || g.GrayAt(0, 0).Y != 0 || g.GrayAt(150, 50).Y != 0 {
+				t.Error("FAIL")
+			}
+		}
+		r.Close()
+	}
+	if !strings.Contains(config, "numFast = 100\n") || !strings.Contains(config, "jobDir = test\n") {
+		t.Error("FAIL")
+	}
+	// the used material should be the cylinder volume (ml)
+	var volume float64
+	for _, l := range strings.Split(config, "\n") {
+		fmt.Sscanf(l, "usedMaterial = %f", &volume)
+	}
+	if math.Abs(volume-Pi*100*5/1000) > 0.005 {
+		t.Error("FAIL")
+	}
+	// too big for the build area
+	if EncodeMSLA(&b, Cylinder3D(5, 15, 0), "test", k) == nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Contours(t *testing.T) {
+	// a square with a round hole
+	s0 := Difference2D(Box2D(V2{20, 20}, 0), Circle2D(5))
+	lines := marchingSquaresQuadtreeLines(s0, 0.1, 4, nil)
+	closed, open := chainLines(lines, 1e-4)
+	if len(closed) != 2 || len(open) != 0 {
+		t.Fatal("FAIL")
+	}
+	s1, err := newContourSDF2(closed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []V2{{0, 0}, {7, 7}, {-9, 1}, {12, 3}, {-20, 0}, {4, 0}, {0, 9.5}} {
+		if math.Abs(s0.Evaluate(p)-s1.Evaluate(p)) > 0.01 {
+			t.Error("FAIL")
+		}
+	}
+
+	// a square with a hole, an island in the hole and a separate square
+	s2 := Union2D(
+		Difference2D(Box2D(V2{40, 40}, 0), Circle2D(15)),
+		Circle2D(5),
+		Transform2D(Box2D(V2{10, 10}, 0), Translate2d(V2{40, 0})),
+	)
+	roots := RenderContours(s2, 400)
+	if len(roots) != 2 || len(FlattenContours(roots)) != 4 {
+		t.Fatal("FAIL")
+	}
+	if roots[0].Area() < roots[1].Area() {
+		roots[0], roots[1] = roots[1], roots[0]
+	}
+	hole := roots[0].Children
+	if len(hole) != 1 || !hole[0].Hole || hole[0].Parent != roots[0] || hole[0].Area() > 0 {
+		t.Fatal("FAIL")
+	}
+	island := hole[0].Children
+	if len(island) != 1 || island[0].Hole || island[0].Area() < 0 || len(island[0].Children) != 0 {
+		t.Fatal("FAIL")
+	}
+	if math.Abs(island[0].Area()-Pi*25) > 0.1 || math.Abs(hole[0].Area()+Pi*225) > 0.5 {
+		t.Error("FAIL")
+	}
+	if len(island[0].Polygon().Vertices()) != len(island[0].Points) {
+		t.Error("FAIL")
+	}
+	s3, err := Contour2D(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []V2{{0, 0}, {0, 10}, {18, 18}, {40, 1}, {30, 0}} {
+		if math.Abs(s2.Evaluate(p)-s3.Evaluate(p)) > 0.01 {
+			t.Error("FAIL")
+		}
+	}
+	r, err := NewRenderer(&RenderParms{MeshCells: 200, Refine: 4}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, _, err = r.Contours(context.Background(), s2)
+	if err != nil || len(FlattenContours(roots)) != 4 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_GCode(t *testing.T) {
+	s := Difference3D(Box3D(V3{20, 20, 2}, 0), Cylinder3D(3, 5, 0))
+	k := &GCodeParms{
+		SolidLayers: 10,
+		Origin:      V2{100, 100},
+		Retraction:  1,
+		NozzleTemp:  210,
+	}
+	var b bytes.Buffer
+	if err := EncodeGCode(&b, s, k); err != nil {
+		t.Fatal(err)
+	}
+	// check the extrusion moves
+	var e float64
+	for _, l := range strings.Split(b.String(), "\n") {
+		var x, y, de float64
+		if n, _ := fmt.Sscanf(l, "G1 X%f Y%f E%f", &x, &y, &de); n == 3 {
+			if x < 90 || x > 110 || y < 90 || y > 110 || (x-100)*(x-100)+(y-100)*(y-100) < 25 {
+				t.Fatal("FAIL")
+			}
+			e += de
+		}
+	}
+	if !strings.Contains(b.String(), ";LAYER:9\n") || strings.Contains(b.String(), ";LAYER:10\n") {
+		t.Error("FAIL")
+	}
+	// solid infill, the extruded volume should be close to the part volume
+	volume := e * Pi * 0.25 * 1.75 * 1.75
+	if math.Abs(volume/(800-Pi*25*2)-1) > 0.1 {
+		t.Error("FAIL")
+	}
+	// perimeters
+	k = &GCodeParms{Perimeters: 2, SolidLayers: 2, InfillDensity: 0.2}
+	b.Reset()
+	if err := EncodeGCode(&b, s, k); err != nil {
+		t.Fatal(err)
+	}
+	if EncodeGCode(&b, s, &GCodeParms{InfillDensity: 2}) == nil {
+		t.Error("FAIL")
+	}
+	// count the extrusion moves
+	extrusions := func(k *GCodeParms) int {
+		b.Reset()
+		if err := EncodeGCode(&b, s, k); err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, l := range strings.Split(b.String(), "\n") {
+			var x, y, de float64
+			if n0, _ := fmt.Sscanf(l, "G1 X%f Y%f E%f", &x, &y, &de); n0 == 3 && de > 0 {
+				n++
+			}
+		}
+		return n
+	}
+	// the zero value has perimeters and solid layers
+	if extrusions(&GCodeParms{}) == 0 {
+		t.Error("FAIL")
+	}
+	// negative values are none
+	if extrusions(&GCodeParms{Perimeters: -1, SolidLayers: -1}) != 0 ||
+		extrusions(&GCodeParms{Perimeters: -1, SolidLayers: -1, InfillDensity: 0.2}) == 0 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_DXF(t *testing.T) {
+	// polygon with a smoothed corner and an arc
+	p := NewPolygon()
+	p.Add(0, 0)
+	p.Add(10, 0).Smooth(2, 5)
+	p.Add(10, 10)
+	p.Add(0, 10).Arc(5, 8)
+	p.Close()
+	d := NewDXF("test.dxf")
+	if d.Units("mm") != nil || d.Units("furlong") == nil {
+		t.Error("FAIL")
+	}
+	d.Layer("cut", color.RGBA{255, 0, 0, 255})
+	d.Polygon(p)
+	d.Layer("engrave", color.RGBA{0, 0, 255, 255})
+	d.Contours(RenderContours(Circle2D(5), 200), 0.05)
+	var b bytes.Buffer
+	if err := d.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	// read the group codes and values
+	lines := strings.Split(b.String(), "\n")
+	var codes, values []string
+	for i := 0; i+1 < len(lines); i += 2 {
+		codes = append(codes, strings.TrimSpace(lines[i]))
+		values = append(values, strings.TrimSpace(lines[i+1]))
+	}
+	find := func(code, value string, start int) int {
+		for i := start; i < len(codes); i++ {
+			if codes[i] == code && values[i] == value {
+				return i
+			}
+		}
+		return -1
+	}
+	if i := find("9", "$INSUNITS", 0); i < 0 || values[i+1] != "4" {
+		t.Error("FAIL")
+	}
+	// the polygon is a closed polyline on the cut layer
+	i := find("0", "LWPOLYLINE", 0)
+	if i < 0 || values[i+3] != "cut" {
+		t.Fatal("FAIL")
+	}
+	var bulges []float64
+	n := 0
+	for j := i + 1; codes[j] != "0"; j++ {
+		switch codes[j] {
+		case "10":
+			n++
+		case "42":
+			var x float64
+			fmt.Sscanf(values[j], "%f", &x)
+			bulges = append(bulges, x)
+		}
+	}
+	// fillet: 90 degrees counter-clockwise, arc: 180 degrees clockwise
+	if n != 5 || len(bulges) != 2 || math.Abs(bulges[0]-math.Tan(Pi/8)) > 1e-6 || math.Abs(bulges[1]+1) > 1e-6 {
+		t.Error("FAIL")
+	}
+	// the contour is a circle on the engrave layer
+	i = find("0", "CIRCLE", 0)
+	if i < 0 || values[i+3] != "engrave" {
+		t.Fatal("FAIL")
+	}
+	var r float64
+	for j := i + 1; codes[j] != "0"; j++ {
+		if codes[j] == "40" {
+			fmt.Sscanf(values[j], "%f", &r)
+		}
+	}
+	if math.Abs(r-5) > 0.01 {
+		t.Error("FAIL")
+	}
+	// the layer colors
+	if i = find("2", "cut", 0); i < 0 || find("62", "1", i) < 0 {
+		t.Error("FAIL")
+	}
+	// render the contours of a washer
+	rd, _ := NewRenderer(&RenderParms{MeshCells: 200}, 0, nil)
+	b.Reset()
+	if _, err := rd.EncodeDXFContours(context.Background(), Difference2D(Circle2D(8), Circle2D(4)), &b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(b.String(), "\nCIRCLE\n") != 2 {
+		t.Error("FAIL")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := rd.EncodeDXFContours(ctx, Circle2D(5), &b); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_DXFLoad(t *testing.T) {
+	// group codes and values
+	groups := []string{
+		"0", "SECTION", "2", "HEADER", "9", "$INSUNITS", "70", "4", "0", "ENDSEC",
+		"0", "SECTION", "2", "ENTITIES",
+		// 40x20 outline with a semicircular end
+		"0", "LWPOLYLINE", "8", "0", "90", "4", "70", "1",
+		"10", "0", "20", "0", "10", "40", "20", "0", "42", "1", "10", "40", "20", "20", "10", "0", "20", "20",
+		// round hole
+		"0", "CIRCLE", "8", "holes", "10", "10", "20", "10", "40", "3",
+		// slot
+		"0", "LINE", "8", "holes", "10", "20", "20", "7", "11", "30", "21", "7",
+		"0", "ARC", "8", "holes", "10", "30", "20", "10", "40", "3", "50", "270", "51", "90",
+		"0", "LINE", "8", "holes", "10", "30.0000001", "20", "13", "11", "20", "21", "13",
+		"0", "ARC", "8", "holes", "10", "20", "20", "10", "40", "3", "50", "90", "51", "270",
+		// ellipse
+		"0", "ELLIPSE", "8", "0", "10", "-30", "20", "0", "11", "10", "21", "0", "40", "0.5", "41", "0", "42", "6.283185307179586",
+		// parabolic segment
+		"0", "SPLINE", "8", "0", "70", "8", "71", "2", "72", "6", "73", "3",
+		"40", "0", "40", "0", "40", "0", "40", "1", "40", "1", "40", "1",
+		"10", "60", "20", "0", "10", "70", "20", "20", "10", "80", "20", "0",
+		"0", "LINE", "8", "0", "10", "80", "20", "0", "11", "60", "21", "0",
+		// open line
+		"0", "LINE", "8", "dims", "10", "0", "20", "-5", "11", "40", "21", "-5",
+		"0", "ENDSEC", "0", "EOF",
+	}
+	data := strings.Join(groups, "\n") + "\n"
+	roots, err := DecodeDXF(strings.NewReader(data), &DXFLoadParms{MaxError: 1e-3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contours := FlattenContours(roots)
+	if len(roots) != 3 || len(contours) != 5 {
+		t.Fatal("FAIL")
+	}
+	var area float64
+	holes := 0
+	for _, c := range contours {
+		area += c.Area()
+		if c.Hole {
+			holes++
+		}
+	}
+	expected := (800 + 50*Pi) - 9*Pi - (60 + 9*Pi) + 50*Pi + 400.0/3
+	if holes != 2 || math.Abs(area-expected) > 0.1 {
+		t.Error("FAIL")
+	}
+	s, err := DecodeDXF2D(strings.NewReader(data), &DXFLoadParms{MaxError: 1e-3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []V2{{5, 5}, {45, 10}, {-30, 4}, {70, 9}, {36, 10}} {
+		if s.Evaluate(p) >= 0 {
+			t.Error("FAIL")
+		}
+	}
+	for _, p := range []V2{{10, 10}, {25, 10}, {18, 10}, {70, 11}, {-30, 6}, {20, -5}} {
+		if s.Evaluate(p) <= 0 {
+			t.Error("FAIL")
+		}
+	}
+	// layer filter
+	roots, err = DecodeDXF(strings.NewReader(data), &DXFLoadParms{Layers: []string{"holes"}})
+	if err != nil || len(roots) != 2 || len(FlattenContours(roots)) != 2 {
+		t.Error("FAIL")
+	}
+	if _, err = DecodeDXF(strings.NewReader(data), &DXFLoadParms{Layers: []string{"dims"}}); err == nil {
+		t.Error("FAIL")
+	}
+	// malformed input is an error, not a panic
+	bad := "0\nSECTION\n2\nENTITIES\n0\nLWPOLYLINE\n8\n0\n20\n5\n10\n0\n0\nENDSEC\n0\nEOF\n"
+	if _, err = DecodeDXF(strings.NewReader(bad), nil); err == nil {
+		t.Error("FAIL")
+	}
+	// round trip
+	d := NewDXF("test.dxf")
+	d.Contours(roots, 0.01)
+	var b bytes.Buffer
+	if err := d.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	roots, err = DecodeDXF(&b, nil)
+	if err != nil || len(roots) != 2 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_SVGLoad(t *testing.T) {
+	svg := `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="100mm" height="100mm" viewBox="0 0 100 100">
+  <defs><rect x="0" y="0" width="100" height="100"/></defs>
+  <g transform="translate(5,0)">
+    <rect x="5" y="10" width="30" height="30" rx="5" transform="scale(1)"/>
+  </g>
+  <path fill-rule="evenodd" d="M60,10 h30 v30 h-30 z m10,10 h10 v10 h-10 z"/>
+  <g style="fill:#000;fill-rule:evenodd">
+    <path style="fill-rule:nonzero" d="M60,60 H90 V90 H60 Z M70,70 L80,70 80,80 70,80 Z"/>
+    <path fill-rule="nonzero" d="M10,75 a10,10 0 1,0 20,0 a10,10 0 1,0 -20,0 z M15,75 a5,5 0 1,1 10,0 a5,5 0 1,1 -10,0 z"/>
+  </g>
+  <path d="M45,60 C50.523,60 55,64.477 55,70 S50.523,80 45,80 S35,75.523 35,70 S39.477,60 45,60 Z"/>
+  <path d="M5,95 Q15,85 25,95 T35,95 Z"/>
+  <polygon points="60,95 70,95 65,99"/>
+  <ellipse cx="90" cy="95" rx="5" ry="3" fill="none"/>
+</svg>`
+	s, err := DecodeSVG2D(strings.NewReader(svg), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// y is up, the bottom left corner is at the origin
+	inside := []V2{{25, 75}, {65, 75}, {75, 25}, {12, 25}, {15, 9}, {33, 3}, {65, 3}}
+	outside := []V2{{10.5, 89.5}, {75, 75}, {20, 25}, {90, 5}, {50, 50}}
+	for _, p := range inside {
+		if s.Evaluate(p) >= 0 {
+			t.Error("FAIL", p)
+		}
+	}
+	for _, p := range outside {
+		if s.Evaluate(p) <= 0 {
+			t.Error("FAIL", p)
+		}
+	}
+	// bezier circle
+	if math.Abs(s.Evaluate(V2{45, 30})+10) > 0.05 {
+		t.Error("FAIL")
+	}
+	// arcs
+	if math.Abs(s.Evaluate(V2{20, 17.5})+2.5) > 0.05 {
+		t.Error("FAIL")
+	}
+	if _, err := DecodeSVG2D(strings.NewReader(`<svg><path d="M0,0 L10"/></svg>`), nil); err == nil {
+		t.Error("FAIL")
+	}
+	// an arc too large to flatten doesn't panic
+	if _, err := DecodeSVG2D(strings.NewReader(`<svg><path d="M0,0 A1e300,1e300 0 0 1 1e300,0 L0,1e300 Z"/></svg>`), nil); err != nil {
+		t.Error("FAIL")
+	}
+	if _, err := DecodeSVG2D(strings.NewReader(`<svg><rect width="10" height="10" fill="none"/></svg>`), nil); err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_SVGContours(t *testing.T) {
+	// a plate with a hole and a separate washer
+	plate := Difference2D(Box2D(V2{40, 20}, 0), Circle2D(5))
+	washer := Transform2D(Difference2D(Circle2D(8), Circle2D(4)), Translate2d(V2{40, 0}))
+	d := NewSVG("test.svg", "")
+	d.Layer("cut", &SVGStyle{Stroke: color.RGBA{255, 0, 0, 255}, StrokeWidth: 0.2})
+	d.Contours(RenderContours(plate, 200))
+	d.Layer("engrave & mark", &SVGStyle{Fill: color.NRGBA{0, 0, 255, 128}})
+	d.Contours(RenderContours(washer, 200))
+	d.Layer("1 cut", nil)
+	var b bytes.Buffer
+	if err := d.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	// check the document
+	var doc struct {
+		Width   string `xml:"width,attr"`
+		Height  string `xml:"height,attr"`
+		ViewBox string `xml:"viewBox,attr"`
+		Groups  []struct {
+			ID    string `xml:"id,attr"`
+			Label string `xml:"label,attr"`
+			Style string `xml:"style,attr"`
+			Paths []struct {
+				D string `xml:"d,attr"`
+			} `xml:"path"`
+		} `xml:"g"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Width != "68.00mm" || doc.Height != "20.00mm" || doc.ViewBox != "0 0 68.00 20.00" {
+		t.Error("FAIL")
+	}
+	// valid and unique ids, the layer names are the labels
+	if len(doc.Groups) != 3 || doc.Groups[0].ID != "cut" || doc.Groups[1].ID != "engrave_mark" || doc.Groups[2].ID != "cut-2" {
+		t.Fatal("FAIL")
+	}
+	if doc.Groups[1].Label != "engrave & mark" || doc.Groups[2].Label != "1 cut" {
+		t.Error("FAIL")
+	}
+	if doc.Groups[0].Style != "fill:none;stroke:#ff0000;stroke-width:0.2" ||
+		doc.Groups[1].Style != "fill:#0000ff;fill-rule:evenodd;fill-opacity:0.502;stroke:none" {
+		t.Error("FAIL")
+	}
+	// one path per outline, with the hole
+	for _, g := range doc.Groups[:2] {
+		if len(g.Paths) != 1 || strings.Count(g.Paths[0].D, "M") != 2 {
+			t.Error("FAIL")
+		}
+	}
+	// read back the filled layer, the bottom left corner is at the origin
+	s, err := DecodeSVG2D(&b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := V2{-20, -10}
+	for _, p := range []V2{{40, 0}, {34, 0}, {40, 6}, {20, 0}} {
+		if math.Abs(s.Evaluate(p.Sub(offset))-washer.Evaluate(p)) > 0.05 {
+			t.Error("FAIL")
+		}
+	}
+	// render the contours through a renderer, a nil style is black filled paths
+	r, _ := NewRenderer(&RenderParms{MeshCells: 200}, 0, nil)
+	b.Reset()
+	if _, err := r.EncodeSVGContours(context.Background(), plate, &b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(b.String(), "<path") != 1 || !strings.Contains(b.String(), "fill:#000000") {
+		t.Error("FAIL")
+	}
+	// line segments keep the unitless sizing
+	b.Reset()
+	if err := EncodeSVG(&b, "", []*Line{{V2{0, 0}, V2{10, 5}}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `width="10.00" height="5.00"`) || strings.Contains(b.String(), "viewBox") {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Preview(t *testing.T) {
+	s := Sphere3D(10)
+	white := color.RGBA{255, 255, 255, 255}
+	for _, fov := range []float64{0, DtoR(40)} {
+		img := Preview(s, &PreviewParms{Size: V2i{64, 48}, FOV: fov, Outline: true})
+		if img.Bounds().Dx() != 64 || img.Bounds().Dy() != 48 {
+			t.Fatal("FAIL")
+		}
+		// the sphere is in the middle, with the background at the corners
+		if img.RGBAAt(0, 0) != white || img.RGBAAt(63, 47) != white || img.RGBAAt(32, 24) == white {
+			t.Error("FAIL")
+		}
+		// lit from the upper left
+		if img.RGBAAt(26, 18).R <= img.RGBAAt(38, 30).R {
+			t.Error("FAIL")
+		}
+		// the silhouette is outlined
+		black := 0
+		for x := 0; x < 64; x++ {
+			if img.RGBAAt(x, 24) == (color.RGBA{0, 0, 0, 255}) {
+				black++
+			}
+		}
+		if black != 2 {
+			t.Error("FAIL")
+		}
+	}
+	// looking down, a hole in a plate is background
+	plate := Difference3D(Box3D(V3{20, 20, 2}, 0), Cylinder3D(4, 3, 0))
+	img := Preview(plate, &PreviewParms{Size: V2i{40, 40}, Elevation: DtoR(90), Background: color.Black})
+	if img.RGBAAt(20, 20) != (color.RGBA{0, 0, 0, 255}) || img.RGBAAt(20, 8).R == 0 {
+		t.Error("FAIL")
+	}
+	var b bytes.Buffer
+	if err := EncodePreview(&b, s, nil); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := png.Decode(&b); err != nil || img.Bounds().Dx() != 512 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_PNGStyle(t *testing.T) {
+	bb := Box2{V2{-20, -20}, V2{20, 20}}
+	d, err := NewPNG("", bb, V2i{41, 41})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// inside is blue, outside is orange, the zero contour is black
+	d.RenderSDF2Style(Circle2D(10), &PNGStyle{Mode: PNGDiverging, Zero: color.Black})
+	in := d.img.RGBAAt(20, 20)
+	out := d.img.RGBAAt(1, 1)
+	edge := d.img.RGBAAt(30, 20)
+	if in.B <= in.R || out.R <= out.B || edge.R > 0x40 {
+		t.Error("FAIL")
+	}
+	// isolines vary the brightness along a line of constant color
+	d.RenderSDF2Style(Circle2D(10), &PNGStyle{Mode: PNGDiverging, Isolines: 4})
+	lo, hi := uint8(255), uint8(0)
+	for x := 0; x < 10; x++ {
+		c := d.img.RGBAAt(x, 20).G
+		if c < lo {
+			lo = c
+		}
+		if c > hi {
+			hi = c
+		}
+	}
+	if hi-lo < 0x20 {
+		t.Error("FAIL")
+	}
+	// a true distance field has a unit gradient everywhere (white)
+	d.RenderSDF2Style(Box2D(V2{20, 20}, 0), &PNGStyle{Mode: PNGGradient, GradientRange: 0.5})
+	for _, c := range []color.RGBA{d.img.RGBAAt(5, 5), d.img.RGBAAt(35, 20)} {
+		if c.R < 0xf0 || c.G < 0xf0 || c.B < 0xf0 {
+			t.Error("FAIL")
+		}
+	}
+	// a scaled field does not
+	d.RenderSDF2Style(Transform2D(Circle2D(10), Scale2d(V2{2, 1})), &PNGStyle{Mode: PNGGradient, GradientRange: 0.5})
+	if d.img.RGBAAt(2, 20).R > 0xf0 {
+		t.Error("FAIL")
+	}
+	// a nil style is gray scale
+	d.RenderSDF2Style(Circle2D(10), nil)
+	in, out = d.img.RGBAAt(20, 20), d.img.RGBAAt(1, 1)
+	if in.R != in.B || out.R != out.B || in.R >= out.R {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Gradient(t *testing.T) {
+	// analytic gradients agree with finite differences
+	shapes := []SDF3{
+		Sphere3D(5),
+		Box3D(V3{4, 6, 8}, 0.5),
+		Cylinder3D(6, 3, 0.5),
+	}
+	points := []V3{{1.2, 2, 2}, {4, -1, 0.5}, {-0.5, 0.2, -6}, {7, 8, -9}, {0.1, -0.3, 0.2}}
+	for _, s := range shapes {
+		if _, ok := s.(SDF3Gradient); !ok {
+			t.Fatal("FAIL")
+		}
+		// hide the analytic gradient
+		numeric := Transform3D(s, Identity3d())
+		for _, p := range points {
+			g := Gradient3(s, p, nil)
+			for _, mode := range []GradientMode{GradientCentral, GradientTetrahedral} {
+				gn := Gradient3(numeric, p, &GradientParms{Step: 1e-4, Mode: mode})
+				if !g.Equals(gn, 1e-3) {
+					t.Error("FAIL")
+				}
+			}
+			// closest points are on the surface
+			q := ClosestPoint3(s, p, nil)
+			if Abs(s.Evaluate(q)) > 1e-6 {
+				t.Error("FAIL")
+			}
+		}
+	}
+	if !Normal3(Box3D(V3{2, 2, 2}, 0), V3{0.2, 0.1, 3}, nil).Equals(V3{0, 0, 1}, tolerance) {
+		t.Error("FAIL")
+	}
+	// 2d
+	c := Circle2D(2)
+	numeric := Transform2D(c, Identity2d())
+	for _, mode := range []GradientMode{GradientCentral, GradientTetrahedral} {
+		g := Gradient2(numeric, V2{3, 4}, &GradientParms{Mode: mode})
+		if !g.Equals(V2{0.6, 0.8}, 1e-4) || !Normal2(c, V2{3, 4}, nil).Equals(g, 1e-4) {
+			t.Error("FAIL")
+		}
+	}
+	q := ClosestPoint2(Transform2D(c, Scale2d(V2{2, 1})), V2{5, 1}, nil)
+	if Abs(q.X*q.X/16+q.Y*q.Y/4-1) > 1e-6 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Raycast(t *testing.T) {
+	tube := Difference3D(Cylinder3D(10, 5, 0), Cylinder3D(12, 3, 0))
+	o := V3{-20, 0, 1}
+	// first hit
+	h, ok := Raycast3(tube, o, V3{2, 0, 0}, nil)
+	if !ok || Abs(h.T-15) > 1e-4 || !h.Point.Equals(V3{-5, 0, 1}, 1e-4) || !h.Normal.Equals(V3{-1, 0, 0}, 1e-3) || !h.Enter {
+		t.Error("FAIL")
+	}
+	// starting inside the object
+	h, ok = Raycast3(tube, V3{4, 0, 1}, V3{1, 0, 0}, nil)
+	if !ok || Abs(h.T-1) > 1e-4 || h.Enter {
+		t.Error("FAIL")
+	}
+	// misses and limits
+	if _, ok := Raycast3(tube, V3{-20, 10, 1}, V3{1, 0, 0}, nil); ok {
+		t.Error("FAIL")
+	}
+	if _, ok := Raycast3(tube, o, V3{1, 0, 0}, &RayParms{MaxDistance: 10}); ok {
+		t.Error("FAIL")
+	}
+	// all hits
+	hits := RaycastAll3(tube, o, V3{1, 0, 0}, nil)
+	x := []float64{-5, -3, 3, 5}
+	if len(hits) != len(x) {
+		t.Fatal("FAIL")
+	}
+	for i, h := range hits {
+		if Abs(h.Point.X-x[i]) > 1e-4 || h.Enter != (i%2 == 0) || (h.Normal.X < 0) != h.Enter {
+			t.Error("FAIL")
+		}
+	}
+	// down through the hole onto a boss below
+	part := Union3D(Transform3D(tube, Translate3d(V3{0, 0, 10})), Cylinder3D(4, 2, 0))
+	h, ok = Raycast3(part, V3{0, 0, 30}, V3{0, 0, -1}, nil)
+	if !ok || !h.Point.Equals(V3{0, 0, 2}, 1e-4) || !h.Normal.Equals(V3{0, 0, 1}, 1e-3) {
+		t.Error("FAIL")
+	}
+	if len(RaycastAll3(part, V3{0, 0, 30}, V3{0, 0, -1}, nil)) != 2 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Mass(t *testing.T) {
+	// sphere
+	m := Mass3(Sphere3D(10), &MassParms{Density: 2})
+	v := 4.0 / 3.0 * Pi * 1000
+	if Abs(m.Volume-v) > 1e-3*v || m.VolumeError > 0.2*v || Abs(m.Mass-2*m.Volume) > tolerance {
+		t.Error("FAIL")
+	}
+	if Abs(m.Area-4*Pi*100) > 5e-3*4*Pi*100 || m.Centroid.Length() > 1e-6 {
+		t.Error("FAIL")
+	}
+	i := 0.4 * m.Mass * 100
+	if Abs(m.Inertia.x00-i) > 1e-3*i || Abs(m.Inertia.x11-i) > 1e-3*i || Abs(m.Inertia.x22-i) > 1e-3*i || Abs(m.Inertia.x01) > 1e-6*i {
+		t.Error("FAIL")
+	}
+	// offset box
+	m = Mass3(Transform3D(Box3D(V3{10, 20, 30}, 0), Translate3d(V3{5, 1, 2})), nil)
+	if Abs(m.Volume-6000) > 1 || Abs(m.Area-2200) > 20 || !m.Centroid.Equals(V3{5, 1, 2}, 1e-2) {
+		t.Error("FAIL")
+	}
+	for j, x := range []float64{6000.0 / 12 * (400 + 900), 6000.0 / 12 * (100 + 900), 6000.0 / 12 * (100 + 400)} {
+		if Abs([]float64{m.Inertia.x00, m.Inertia.x11, m.Inertia.x22}[j]-x) > 1e-3*x {
+			t.Error("FAIL")
+		}
+	}
+	// 2d
+	m2 := Mass2(Transform2D(Circle2D(5), Translate2d(V2{1, 2})), nil)
+	if Abs(m2.Area-Pi*25) > 1e-3*Pi*25 || Abs(m2.Perimeter-Tau*5) > 1e-2*Tau*5 || !m2.Centroid.Equals(V2{1, 2}, 1e-6) {
+		t.Error("FAIL")
+	}
+	m2 = Mass2(Difference2D(Box2D(V2{20, 10}, 0), Transform2D(Box2D(V2{12, 12}, 0), Translate2d(V2{6, 6}))), nil)
+	if Abs(m2.Area-150) > 0.5 || Abs(m2.Perimeter-60) > 0.5 || !m2.Centroid.Equals(V2{-10.0 / 6, -5.0 / 6}, 1e-2) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_ParallelFor(t *testing.T) {
+	for _, workers := range []int{0, 1, 3} {
+		counts := make([]int32, 100)
+		parallelFor(workers, len(counts), func(i int) {
+			atomic.AddInt32(&counts[i], 1)
+		})
+		for _, n := range counts {
+			if n != 1 {
+				t.Error("FAIL")
+				break
+			}
+		}
+	}
+}
+
+func Test_Thickness(t *testing.T) {
+	// an open box with a thin wall at +x
+	cut := Transform3D(Box3D(V3{34, 30, 20}, 0), Translate3d(V3{2, 0, 5}))
+	s := Difference3D(Box3D(V3{40, 40, 20}, 0), cut)
+	if _, err := AnalyzeThickness(s, &ThicknessParms{}); err == nil {
+		t.Error("FAIL")
+	}
+	if _, err := AnalyzeThickness(s, nil); err == nil {
+		t.Error("FAIL")
+	}
+	a, err := AnalyzeThickness(s, &ThicknessParms{Threshold: 2, Render: &RenderParms{MeshCells: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Thickness) != len(a.Mesh.V) || len(a.Colors()) != len(a.Mesh.V) {
+		t.Fatal("FAIL")
+	}
+	// the inside and outside faces of the thin wall are one region
+	if len(a.Regions) != 1 {
+		t.Fatal("FAIL")
+	}
+	r := a.Regions[0]
+	if Abs(r.Min-1) > 1e-3 || r.Box.Min.X > 19.1 || r.Box.Min.X < 18.9 || r.Box.Max.X < 19.9 || r.Box.Max.Z < 9 || r.Box.Min.Z > -4 {
+		t.Error("FAIL")
+	}
+	// the faces are merged even when a vertex of the same face is as close to
+	// the end of the measuring ray as the opposite face
+	m := &Mesh3{
+		V: []V3{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {0, 0, -0.5}, {1, 0, -0.5}, {0, 1, -0.5}},
+		F: [][3]int{{0, 1, 2}, {3, 5, 4}},
+	}
+	down := func(p V3, d float64) V3 { return p.Sub(V3{0, 0, d}) }
+	if regions := thicknessRegions(m, []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5}, 2, down); len(regions) != 1 || len(regions[0].Vertices) != 6 {
+		t.Error("FAIL")
+	}
+	// the other walls are thick
+	for i, v := range a.Mesh.V {
+		if v.X < 18 && a.Thickness[i] < 2 {
+			t.Error("FAIL")
+			break
+		}
+	}
+	// heat map slice
+	d, err := NewPNG("", Box2{V2{-20, -20}, V2{20, 20}}, V2i{81, 81})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenderThickness(s, 0, nil); err == nil {
+		t.Error("FAIL")
+	}
+	if err := d.RenderThickness(s, 0, &ThicknessParms{Threshold: 2}); err != nil {
+		t.Fatal(err)
+	}
+	thin, thick, empty := d.img.RGBAAt(79, 40), d.img.RGBAAt(3, 40), d.img.RGBAAt(40, 40)
+	if thin.R < 0xc0 || thin.G > 0xc0 || thick.G < thick.R || empty != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Error("FAIL")
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -3,6 +3,10 @@
 
 STL Load/Save
 
+Binary and ASCII STL files can be loaded, binary STL files are saved.
+The path based functions are wrappers around the io.Reader/io.Writer
+based functions.
+
 */
 //-----------------------------------------------------------------------------
 
@@ -10,9 +14,15 @@ package sdf
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -32,113 +42,236 @@ type STLTriangle struct {
 
 //-----------------------------------------------------------------------------
 
-// SaveSTL writes a triangle mesh to an STL file.
-func SaveSTL(path string, mesh []*Triangle3) error {
-	file, err := os.Create(path)
+// DecodeSTL reads a triangle mesh in binary or ASCII STL format.
+func DecodeSTL(r io.Reader) ([]*Triangle3, error) {
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// A binary file has a known size. ASCII files start with "solid",
+	// but so do some binary files, so check the size first.
+	if len(data) >= 84 {
+		count := binary.LittleEndian.Uint32(data[80:84])
+		if uint64(len(data)) == 84+50*uint64(count) {
+			return decodeBinarySTL(data[84:], int(count))
+		}
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("solid")) {
+		return decodeASCIISTL(data)
+	}
+	return nil, errors.New("bad STL file")
+}
+
+// decodeBinarySTL reads the triangles from binary STL data.
+func decodeBinarySTL(data []byte, count int) ([]*Triangle3, error) {
+	mesh := make([]*Triangle3, 0, count)
+	r := bytes.NewReader(data)
+	var d STLTriangle
+	for i := 0; i < count; i++ {
+		if err := binary.Read(r, binary.LittleEndian, &d); err != nil {
+			return nil, err
+		}
+		t := NewTriangle3(
+			V3{float64(d.Vertex1[0]), float64(d.Vertex1[1]), float64(d.Vertex1[2])},
+			V3{float64(d.Vertex2[0]), float64(d.Vertex2[1]), float64(d.Vertex2[2])},
+			V3{float64(d.Vertex3[0]), float64(d.Vertex3[1]), float64(d.Vertex3[2])},
+		)
+		mesh = append(mesh, t)
+	}
+	return mesh, nil
+}
+
+// decodeASCIISTL reads the triangles from ASCII STL data.
+func decodeASCIISTL(data []byte) ([]*Triangle3, error) {
+	var mesh []*Triangle3
+	var v []V3
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "vertex":
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("bad vertex on line %d", line)
+			}
+			var p [3]float64
+			for i := range p {
+				x, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil {
+					return nil, fmt.Errorf("bad vertex on line %d: %s", line, err)
+				}
+				p[i] = x
+			}
+			v = append(v, V3{p[0], p[1], p[2]})
+		case "endloop":
+			if len(v) != 3 {
+				return nil, fmt.Errorf("facet with %d vertices on line %d", len(v), line)
+			}
+			mesh = append(mesh, NewTriangle3(v[0], v[1], v[2]))
+			v = v[:0]
+		}
 	}
-	defer file.Close()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mesh, nil
+}
 
-	buf := bufio.NewWriter(file)
+// LoadSTL reads a triangle mesh from a binary or ASCII STL file.
+func LoadSTL(path string) ([]*Triangle3, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeSTL(f)
+}
+
+//-----------------------------------------------------------------------------
+
+// stlTriangle returns the STL file data for a triangle.
+func stlTriangle(t *Triangle3) *STLTriangle {
+	var d STLTriangle
+	n := t.Normal()
+	d.Normal[0] = float32(n.X)
+	d.Normal[1] = float32(n.Y)
+	d.Normal[2] = float32(n.Z)
+	d.Vertex1[0] = float32(t.V[0].X)
+	d.Vertex1[1] = float32(t.V[0].Y)
+	d.Vertex1[2] = float32(t.V[0].Z)
+	d.Vertex2[0] = float32(t.V[1].X)
+	d.Vertex2[1] = float32(t.V[1].Y)
+	d.Vertex2[2] = float32(t.V[1].Z)
+	d.Vertex3[0] = float32(t.V[2].X)
+	d.Vertex3[1] = float32(t.V[2].Y)
+	d.Vertex3[2] = float32(t.V[2].Z)
+	return &d
+}
+
+// EncodeSTL writes a triangle mesh in binary STL format to a writer.
+func EncodeSTL(w io.Writer, mesh []*Triangle3) error {
+	buf := bufio.NewWriter(w)
 	header := STLHeader{}
 	header.Count = uint32(len(mesh))
 	if err := binary.Write(buf, binary.LittleEndian, &header); err != nil {
 		return err
 	}
-
-	var d STLTriangle
 	for _, triangle := range mesh {
-		n := triangle.Normal()
-		d.Normal[0] = float32(n.X)
-		d.Normal[1] = float32(n.Y)
-		d.Normal[2] = float32(n.Z)
-		d.Vertex1[0] = float32(triangle.V[0].X)
-		d.Vertex1[1] = float32(triangle.V[0].Y)
-		d.Vertex1[2] = float32(triangle.V[0].Z)
-		d.Vertex2[0] = float32(triangle.V[1].X)
-		d.Vertex2[1] = float32(triangle.V[1].Y)
-		d.Vertex2[2] = float32(triangle.V[1].Z)
-		d.Vertex3[0] = float32(triangle.V[2].X)
-		d.Vertex3[1] = float32(triangle.V[2].Y)
-		d.Vertex3[2] = float32(triangle.V[2].Z)
-		if err := binary.Write(buf, binary.LittleEndian, &d); err != nil {
+		if err := binary.Write(buf, binary.LittleEndian, stlTriangle(triangle)); err != nil {
 			return err
 		}
 	}
-
 	return buf.Flush()
 }
 
+// SaveSTL writes a triangle mesh to an STL file.
+func SaveSTL(path string, mesh []*Triangle3) error {
+	return saveFile(path, func(w io.Writer) error {
+		return EncodeSTL(w, mesh)
+	})
+}
+
 //-----------------------------------------------------------------------------
 
+// WriteSTLTo writes a stream of triangles in binary STL format to a writer.
+// If the writer is an io.WriteSeeker the triangles are written as they are
+// received and the header is rewritten with the triangle count at the end.
+// Otherwise the triangles are buffered and written when the channel is closed.
+// The write error (nil on success) is sent on the error channel when the
+// writing is complete.
+func WriteSTLTo(wg *sync.WaitGroup, w io.Writer) (chan<- *Triangle3, <-chan error) {
+	return writeSTL(wg, w, nil)
+}
+
 // WriteSTL writes a stream of triangles to an STL file.
+// Write errors are printed, use WriteSTLTo to handle them.
 func WriteSTL(wg *sync.WaitGroup, path string) (chan<- *Triangle3, error) {
-
 	f, err := os.Create(path)
 	if err != nil {
 		return nil, err
 	}
+	c, errc := writeSTL(wg, f, f.Close)
+	printWriteError(wg, errc)
+	return c, nil
+}
 
-	// Use buffered IO for optimal IO writes.
-	// The default buffer size doesn't appear to limit performance.
-	buf := bufio.NewWriter(f)
-
-	// write an empty header
-	hdr := STLHeader{}
-	if err := binary.Write(buf, binary.LittleEndian, &hdr); err != nil {
-		return nil, err
-	}
+// writeSTL writes a stream of triangles to a writer.
+// The optional done function is called when the writing is complete.
+func writeSTL(wg *sync.WaitGroup, w io.Writer, done func() error) (chan<- *Triangle3, <-chan error) {
 
 	// External code writes triangles to this channel.
-	// This goroutine reads the channel and writes triangles to the file.
+	// This goroutine reads the channel and writes triangles to the writer.
 	c := make(chan *Triangle3)
+	errc := make(chan error, 1)
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer f.Close()
-
-		var count uint32
-		var d STLTriangle
-		// read triangles from the channel and write them to the file
-		for t := range c {
-			n := t.Normal()
-			d.Normal[0] = float32(n.X)
-			d.Normal[1] = float32(n.Y)
-			d.Normal[2] = float32(n.Z)
-			d.Vertex1[0] = float32(t.V[0].X)
-			d.Vertex1[1] = float32(t.V[0].Y)
-			d.Vertex1[2] = float32(t.V[0].Z)
-			d.Vertex2[0] = float32(t.V[1].X)
-			d.Vertex2[1] = float32(t.V[1].Y)
-			d.Vertex2[2] = float32(t.V[1].Z)
-			d.Vertex3[0] = float32(t.V[2].X)
-			d.Vertex3[1] = float32(t.V[2].Y)
-			d.Vertex3[2] = float32(t.V[2].Z)
-			if err := binary.Write(buf, binary.LittleEndian, &d); err != nil {
-				fmt.Printf("%s\n", err)
-				return
+		err := streamSTL(w, c)
+		// don't block the sender after an error
+		for range c {
+		}
+		if done != nil {
+			if derr := done(); err == nil {
+				err = derr
 			}
-			count++
 		}
-		// flush the triangles
-		buf.Flush()
+		errc <- err
+	}()
 
-		// back to the start of the file
-		if _, err := f.Seek(0, 0); err != nil {
-			fmt.Printf("%s\n", err)
-			return
+	return c, errc
+}
+
+// streamSTL writes the triangles read from a channel to a writer.
+func streamSTL(w io.Writer, c <-chan *Triangle3) error {
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		// buffer the triangles until we know the count
+		var mesh []*Triangle3
+		for t := range c {
+			mesh = append(mesh, t)
 		}
-		// rewrite the header with the correct mesh count
-		hdr.Count = count
-		if err := binary.Write(f, binary.LittleEndian, &hdr); err != nil {
-			fmt.Printf("%s\n", err)
-			return
+		return EncodeSTL(w, mesh)
+	}
+
+	// the header is rewritten at the current position
+	start, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	// Use buffered IO for optimal IO writes.
+	// The default buffer size doesn't appear to limit performance.
+	buf := bufio.NewWriter(ws)
+
+	// write an empty header
+	hdr := STLHeader{}
+	if err := binary.Write(buf, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+
+	// read triangles from the channel and write them to the file
+	for t := range c {
+		if err := binary.Write(buf, binary.LittleEndian, stlTriangle(t)); err != nil {
+			return err
 		}
-	}()
+		hdr.Count++
+	}
+	// flush the triangles
+	if err := buf.Flush(); err != nil {
+		return err
+	}
 
-	return c, nil
+	// back to the start of the STL data
+	if _, err := ws.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	// rewrite the header with the correct mesh count
+	return binary.Write(ws, binary.LittleEndian, &hdr)
 }
 
 //-----------------------------------------------------------------------------
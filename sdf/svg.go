@@ -3,27 +3,88 @@
 
 SVG Rendering Code
 
+Line segments are drawn with a line style. Closed contours are drawn as
+filled paths, each outline is a single path with its holes and the even-odd
+fill rule. The paths are in named groups (Inkscape layers) with their own
+fill and stroke colors. When there are layers the drawing units are
+millimeters, the width, height and view box are set so the file prints at
+1:1 scale. Line segment only drawings keep the unitless (px) sizing.
+
 */
 //-----------------------------------------------------------------------------
 
 package sdf
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
 	"fmt"
-	"os"
+	"image/color"
+	"io"
+	"strings"
 	"sync"
+	"unicode"
 
 	svg "github.com/ajstarks/svgo/float"
 )
 
 //-----------------------------------------------------------------------------
 
+// SVGStyle is the style for a layer of filled paths.
+type SVGStyle struct {
+	Fill        color.Color // fill color (nil == no fill)
+	Stroke      color.Color // stroke color (nil == no stroke)
+	StrokeWidth float64     // stroke width (mm, default 0.1)
+}
+
+// svgColor returns an SVG color and opacity.
+func svgColor(c color.Color) (string, float64) {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B), float64(n.A) / 255
+}
+
+// style returns the style attribute for a layer.
+func (k *SVGStyle) style() string {
+	var s []string
+	if k.Fill == nil {
+		s = append(s, "fill:none")
+	} else {
+		c, opacity := svgColor(k.Fill)
+		s = append(s, "fill:"+c, "fill-rule:evenodd")
+		if opacity != 1 {
+			s = append(s, fmt.Sprintf("fill-opacity:%.3g", opacity))
+		}
+	}
+	if k.Stroke == nil {
+		s = append(s, "stroke:none")
+	} else {
+		c, opacity := svgColor(k.Stroke)
+		width := k.StrokeWidth
+		if width <= 0 {
+			width = 0.1
+		}
+		s = append(s, "stroke:"+c, fmt.Sprintf("stroke-width:%g", width))
+		if opacity != 1 {
+			s = append(s, fmt.Sprintf("stroke-opacity:%.3g", opacity))
+		}
+	}
+	return strings.Join(s, ";")
+}
+
+// svgLayer is a named group of filled paths.
+type svgLayer struct {
+	name     string
+	style    SVGStyle
+	contours []*Contour
+}
+
 // SVG represents an SVG renderer.
 type SVG struct {
 	filename  string
 	lineStyle string
 	p0s, p1s  []V2
-	min, max  V2
+	layers    []*svgLayer
 }
 
 // NewSVG returns an SVG renderer.
@@ -36,62 +97,179 @@ func NewSVG(filename, lineStyle string) *SVG {
 
 // Line outputs a line to the SVG file.
 func (s *SVG) Line(p0, p1 V2) {
-	if len(s.p0s) == 0 {
-		s.min = p0.Min(p1)
-		s.max = p0.Max(p1)
-	} else {
-		s.min = s.min.Min(p0)
-		s.min = s.min.Min(p1)
-		s.max = s.max.Max(p0)
-		s.max = s.max.Max(p1)
-	}
 	s.p0s = append(s.p0s, p0)
 	s.p1s = append(s.p1s, p1)
 }
 
-// Save closes the SVG file.
-func (s *SVG) Save() error {
-	f, err := os.Create(s.filename)
-	if err != nil {
-		return err
+// Layer adds a named layer of filled paths and makes it the current layer.
+// Use a nil style for black filled paths.
+func (s *SVG) Layer(name string, k *SVGStyle) {
+	if k == nil {
+		k = &SVGStyle{Fill: color.Black}
 	}
+	s.layers = append(s.layers, &svgLayer{name: name, style: *k})
+}
+
+// Contours outputs a tree of contours (e.g. from RenderContours) as filled
+// paths on the current layer.
+func (s *SVG) Contours(contours []*Contour) {
+	if len(s.layers) == 0 {
+		s.Layer("Layer 1", nil)
+	}
+	l := s.layers[len(s.layers)-1]
+	l.contours = append(l.contours, contours...)
+}
 
-	width := s.max.X - s.min.X
-	height := s.max.Y - s.min.Y
-	canvas := svg.New(f)
-	canvas.Start(width, height)
+// bounds returns the bounding box of the lines and contours.
+func (s *SVG) bounds() Box2 {
+	var points V2Set
+	points = append(points, s.p0s...)
+	points = append(points, s.p1s...)
+	for _, l := range s.layers {
+		for _, c := range FlattenContours(l.contours) {
+			points = append(points, c.Points...)
+		}
+	}
+	if len(points) == 0 {
+		return Box2{}
+	}
+	return Box2{points.Min(), points.Max()}
+}
+
+// svgID returns a valid and unique XML id for a layer name.
+func svgID(name string, used map[string]bool) string {
+	var b strings.Builder
+	for _, r := range name {
+		ok := r == '_' || unicode.IsLetter(r) || (b.Len() != 0 && (r == '-' || r == '.' || unicode.IsDigit(r)))
+		if ok {
+			b.WriteRune(r)
+		} else if b.Len() != 0 && !strings.HasSuffix(b.String(), "_") {
+			b.WriteRune('_')
+		}
+	}
+	id := strings.TrimRight(b.String(), "_")
+	if id == "" {
+		id = "layer"
+	}
+	unique := id
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", id, i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// svgEscape escapes a string for an attribute value.
+func svgEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Encode writes the SVG data to a writer.
+func (s *SVG) Encode(w io.Writer) error {
+	// the svg package doesn't return errors, the buffered writer keeps them
+	buf := bufio.NewWriter(w)
+	bb := s.bounds()
+	size := bb.Size()
+	// svg coordinates are mm with y down
+	xy := func(p V2) (float64, float64) {
+		return p.X - bb.Min.X, bb.Max.Y - p.Y
+	}
+	canvas := svg.New(buf)
+	if len(s.layers) == 0 {
+		canvas.Start(size.X, size.Y)
+	} else {
+		canvas.Startunit(size.X, size.Y, "mm",
+			fmt.Sprintf(`viewBox="0 0 %.*f %.*f"`, canvas.Decimals, size.X, canvas.Decimals, size.Y),
+			`xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape"`)
+	}
+	ids := make(map[string]bool)
+	for _, l := range s.layers {
+		id := svgID(l.name, ids)
+		canvas.Group(fmt.Sprintf(`id="%s"`, id), `inkscape:groupmode="layer"`, fmt.Sprintf(`inkscape:label="%s"`, svgEscape(l.name)), l.style.style())
+		// a path for each outline and its holes
+		for _, c := range FlattenContours(l.contours) {
+			if c.Hole {
+				continue
+			}
+			var d strings.Builder
+			for _, h := range append([]*Contour{c}, c.Children...) {
+				for i, p := range h.Points {
+					cmd := "L"
+					if i == 0 {
+						cmd = "M"
+					}
+					x, y := xy(p)
+					fmt.Fprintf(&d, "%s%.3f,%.3f ", cmd, x, y)
+				}
+				d.WriteString("Z ")
+			}
+			canvas.Path(strings.TrimSpace(d.String()))
+		}
+		canvas.Gend()
+	}
 	for i, p0 := range s.p0s {
-		p1 := s.p1s[i]
-		canvas.Line(p0.X-s.min.X, s.max.Y-p0.Y, p1.X-s.min.X, s.max.Y-p1.Y, s.lineStyle)
+		x0, y0 := xy(p0)
+		x1, y1 := xy(s.p1s[i])
+		canvas.Line(x0, y0, x1, y1, s.lineStyle)
 	}
 	canvas.End()
-	return f.Close()
+	return buf.Flush()
+}
+
+// Save closes the SVG file.
+func (s *SVG) Save() error {
+	return saveFile(s.filename, s.Encode)
 }
 
 //-----------------------------------------------------------------------------
 
-// SaveSVG writes line segments to an SVG file.
-func SaveSVG(path, lineStyle string, mesh []*Line) error {
-	s := NewSVG(path, lineStyle)
+// newLinesSVG returns an SVG renderer for a set of line segments.
+func newLinesSVG(filename, lineStyle string, mesh []*Line) *SVG {
+	s := NewSVG(filename, lineStyle)
 	for _, v := range mesh {
 		s.Line(v[0], v[1])
 	}
-	if err := s.Save(); err != nil {
-		return err
-	}
-	return nil
+	return s
+}
+
+// EncodeSVG writes line segments in SVG format to a writer.
+func EncodeSVG(w io.Writer, lineStyle string, mesh []*Line) error {
+	return newLinesSVG("", lineStyle, mesh).Encode(w)
+}
+
+// SaveSVG writes line segments to an SVG file.
+func SaveSVG(path, lineStyle string, mesh []*Line) error {
+	return newLinesSVG(path, lineStyle, mesh).Save()
 }
 
 //-----------------------------------------------------------------------------
 
+// WriteSVGTo writes a stream of line segments in SVG format to a writer.
+// The SVG data is written when the channel is closed. The write error (nil
+// on success) is sent on the error channel when the writing is complete.
+func WriteSVGTo(wg *sync.WaitGroup, w io.Writer, lineStyle string) (chan<- *Line, <-chan error) {
+	return writeSVG(wg, NewSVG("", lineStyle), func(s *SVG) error {
+		return s.Encode(w)
+	})
+}
+
 // WriteSVG writes a stream of line segments to an SVG file.
+// Write errors are printed, use WriteSVGTo to handle them.
 func WriteSVG(wg *sync.WaitGroup, path, lineStyle string) (chan<- *Line, error) {
+	c, errc := writeSVG(wg, NewSVG(path, lineStyle), (*SVG).Save)
+	printWriteError(wg, errc)
+	return c, nil
+}
 
-	s := NewSVG(path, lineStyle)
+// writeSVG collects a stream of line segments and writes them with the save function.
+func writeSVG(wg *sync.WaitGroup, s *SVG, save func(s *SVG) error) (chan<- *Line, <-chan error) {
 
 	// External code writes line segments to this channel.
 	// This goroutine reads the channel and writes line segments to the file.
 	c := make(chan *Line)
+	errc := make(chan error, 1)
 
 	wg.Add(1)
 	go func() {
@@ -99,13 +277,10 @@ func WriteSVG(wg *sync.WaitGroup, path, lineStyle string) (chan<- *Line, error)
 		for v := range c {
 			s.Line(v[0], v[1])
 		}
-		if err := s.Save(); err != nil {
-			fmt.Printf("%s\n", err)
-			return
-		}
+		errc <- save(s)
 	}()
 
-	return c, nil
+	return c, errc
 }
 
 //-----------------------------------------------------------------------------
@@ -0,0 +1,660 @@
+//-----------------------------------------------------------------------------
+/*
+
+SVG Import
+
+Reads the filled shapes of an SVG file (e.g. artwork from Inkscape) as an
+SDF2. The path, rect, circle, ellipse, polygon and polyline elements are
+read, along with the transforms of their groups. Bezier curves are sampled
+with BezierSpline, arcs are flattened to within a given error.
+
+The subpaths of each shape are nested and the holes are subtracted from the
+enclosing outlines using the even-odd or nonzero fill rule of the shape. The
+shapes are then combined with a union. Subpaths are assumed not to cross
+each other, a single subpath that crosses itself is filled as for nonzero.
+
+The SVG document is mapped to millimeters with y up, the bottom left corner
+of the view box is at the origin.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+
+// SVGLoadParms defines the parameters for reading an SVG file.
+type SVGLoadParms struct {
+	MaxError float64 // maximum distance from an arc to its line segments (mm, default 1e-2)
+}
+
+// maxError returns the arc flattening error.
+func (k *SVGLoadParms) maxError() float64 {
+	if k.MaxError <= 0 {
+		return 1e-2
+	}
+	return k.MaxError
+}
+
+//-----------------------------------------------------------------------------
+// attribute values
+
+// svgScanner reads the numbers, flags and names in path data and attributes.
+type svgScanner struct {
+	s string
+	i int
+}
+
+// skip skips white space and commas.
+func (sc *svgScanner) skip() {
+	for sc.i < len(sc.s) && strings.IndexByte(" \t\r\n,", sc.s[sc.i]) >= 0 {
+		sc.i++
+	}
+}
+
+// done returns true at the end of the data.
+func (sc *svgScanner) done() bool {
+	sc.skip()
+	return sc.i >= len(sc.s)
+}
+
+// isNumber returns true if a number is next.
+func (sc *svgScanner) isNumber() bool {
+	sc.skip()
+	return sc.i < len(sc.s) && strings.IndexByte("0123456789+-.", sc.s[sc.i]) >= 0
+}
+
+// command returns the next path command, if there is one.
+func (sc *svgScanner) command() (byte, bool) {
+	sc.skip()
+	if sc.i < len(sc.s) && strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", sc.s[sc.i]) >= 0 {
+		sc.i++
+		return sc.s[sc.i-1], true
+	}
+	return 0, false
+}
+
+// number returns the next number.
+func (sc *svgScanner) number() (float64, error) {
+	sc.skip()
+	start := sc.i
+	digits := func() bool {
+		i := sc.i
+		for sc.i < len(sc.s) && sc.s[sc.i] >= '0' && sc.s[sc.i] <= '9' {
+			sc.i++
+		}
+		return sc.i > i
+	}
+	sign := func() {
+		if sc.i < len(sc.s) && (sc.s[sc.i] == '+' || sc.s[sc.i] == '-') {
+			sc.i++
+		}
+	}
+	sign()
+	ok := digits()
+	if sc.i < len(sc.s) && sc.s[sc.i] == '.' {
+		sc.i++
+		ok = digits() || ok
+	}
+	if ok && sc.i < len(sc.s) && (sc.s[sc.i] == 'e' || sc.s[sc.i] == 'E') {
+		sc.i++
+		sign()
+		digits()
+	}
+	if !ok {
+		return 0, fmt.Errorf("bad number at \"%s\"", sc.s[start:])
+	}
+	return strconv.ParseFloat(sc.s[start:sc.i], 64)
+}
+
+// flag returns the next arc flag.
+func (sc *svgScanner) flag() (bool, error) {
+	sc.skip()
+	if sc.i < len(sc.s) && (sc.s[sc.i] == '0' || sc.s[sc.i] == '1') {
+		sc.i++
+		return sc.s[sc.i-1] == '1', nil
+	}
+	return false, fmt.Errorf("bad flag at \"%s\"", sc.s[sc.i:])
+}
+
+// name returns the next name (e.g. a transform function).
+func (sc *svgScanner) name() string {
+	sc.skip()
+	start := sc.i
+	for sc.i < len(sc.s) && (sc.s[sc.i] >= 'a' && sc.s[sc.i] <= 'z' || sc.s[sc.i] >= 'A' && sc.s[sc.i] <= 'Z') {
+		sc.i++
+	}
+	return sc.s[start:sc.i]
+}
+
+// expect returns true if the next character is c.
+func (sc *svgScanner) expect(c byte) bool {
+	sc.skip()
+	if sc.i < len(sc.s) && sc.s[sc.i] == c {
+		sc.i++
+		return true
+	}
+	return false
+}
+
+// svgNumbers returns a list of numbers.
+func svgNumbers(s string) ([]float64, error) {
+	sc := &svgScanner{s: s}
+	var x []float64
+	for !sc.done() {
+		v, err := sc.number()
+		if err != nil {
+			return nil, err
+		}
+		x = append(x, v)
+	}
+	return x, nil
+}
+
+// svgUnits maps length units to millimeters.
+var svgUnits = map[string]float64{
+	"":   25.4 / 96,
+	"px": 25.4 / 96,
+	"pt": 25.4 / 72,
+	"pc": 25.4 / 6,
+	"in": 25.4,
+	"cm": 10,
+	"mm": 1,
+}
+
+// svgLength returns a length in millimeters.
+func svgLength(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	i := len(s)
+	for i > 0 && (s[i-1] >= 'a' && s[i-1] <= 'z') {
+		i--
+	}
+	scale, ok := svgUnits[s[i:]]
+	if !ok {
+		return 0, false
+	}
+	x, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, false
+	}
+	return x * scale, true
+}
+
+// svgTransform returns the matrix for a transform attribute.
+func svgTransform(s string) (M33, error) {
+	m := Identity2d()
+	sc := &svgScanner{s: s}
+	for !sc.done() {
+		name := sc.name()
+		if !sc.expect('(') {
+			return m, fmt.Errorf("bad transform \"%s\"", s)
+		}
+		var x []float64
+		for sc.isNumber() {
+			v, err := sc.number()
+			if err != nil {
+				return m, err
+			}
+			x = append(x, v)
+		}
+		if !sc.expect(')') {
+			return m, fmt.Errorf("bad transform \"%s\"", s)
+		}
+		// arguments with defaults
+		arg := func(i int, def float64) float64 {
+			if i < len(x) {
+				return x[i]
+			}
+			return def
+		}
+		var t M33
+		switch name {
+		case "matrix":
+			if len(x) != 6 {
+				return m, fmt.Errorf("bad transform \"%s\"", s)
+			}
+			t = M33{x[0], x[2], x[4], x[1], x[3], x[5], 0, 0, 1}
+		case "translate":
+			t = Translate2d(V2{arg(0, 0), arg(1, 0)})
+		case "scale":
+			t = Scale2d(V2{arg(0, 1), arg(1, arg(0, 1))})
+		case "rotate":
+			c := V2{arg(1, 0), arg(2, 0)}
+			t = Translate2d(c).Mul(Rotate2d(DtoR(arg(0, 0)))).Mul(Translate2d(c.Neg()))
+		case "skewX":
+			t = M33{1, math.Tan(DtoR(arg(0, 0))), 0, 0, 1, 0, 0, 0, 1}
+		case "skewY":
+			t = M33{1, 0, 0, math.Tan(DtoR(arg(0, 0))), 1, 0, 0, 0, 1}
+		default:
+			return m, fmt.Errorf("bad transform \"%s\"", s)
+		}
+		m = m.Mul(t)
+	}
+	return m, nil
+}
+
+// svgStyle returns a presentation attribute or style property.
+// Style properties take precedence over attributes.
+func svgStyle(attrs map[string]string, name string) (string, bool) {
+	for _, decl := range strings.Split(attrs["style"], ";") {
+		kv := strings.SplitN(decl, ":", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == name {
+			return strings.TrimSpace(kv[1]), true
+		}
+	}
+	v, ok := attrs[name]
+	return strings.TrimSpace(v), ok
+}
+
+//-----------------------------------------------------------------------------
+// paths
+
+// bezierPoints returns the points on a bezier curve, not including the first point.
+func bezierPoints(p []V2) V2Set {
+	end := p[len(p)-1]
+	degenerate := true
+	for _, v := range p[1:] {
+		if !v.Equals(p[0], tolerance) {
+			degenerate = false
+		}
+	}
+	if degenerate {
+		return V2Set{end}
+	}
+	// start with two halves, the curve could be closed
+	s := NewBezierSpline(p)
+	poly := NewPolygon()
+	mid := s.f0(0.5)
+	s.Sample(poly, 0, 0.5, p[0], mid, 1)
+	s.Sample(poly, 0.5, 1, mid, end, 1)
+	return poly.Vertices()[1:]
+}
+
+// svgArc returns the points on an elliptical arc, not including the first point.
+// See: https://www.w3.org/TR/SVG11/implnote.html#ArcImplementationNotes
+func svgArc(p0 V2, rx, ry, phi float64, large, sweep bool, p1 V2, maxError float64) V2Set {
+	if p0.Equals(p1, 0) {
+		return nil
+	}
+	rx, ry = Abs(rx), Abs(ry)
+	if rx == 0 || ry == 0 {
+		return V2Set{p1}
+	}
+	sin, cos := math.Sincos(DtoR(phi))
+	d := p0.Sub(p1).MulScalar(0.5)
+	x1 := cos*d.X + sin*d.Y
+	y1 := -sin*d.X + cos*d.Y
+	// scale up the radii if there is no solution
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx *= math.Sqrt(l)
+		ry *= math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	k := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		k = -k
+	}
+	cx1 := k * rx * y1 / ry
+	cy1 := -k * ry * x1 / rx
+	c := V2{cos*cx1 - sin*cy1, sin*cx1 + cos*cy1}.Add(p0.Add(p1).MulScalar(0.5))
+	// start angle and sweep
+	angle := func(u, v V2) float64 {
+		return math.Atan2(u.Cross(v), u.Dot(v))
+	}
+	u := V2{(x1 - cx1) / rx, (y1 - cy1) / ry}
+	v := V2{(-x1 - cx1) / rx, (-y1 - cy1) / ry}
+	t0 := angle(V2{1, 0}, u)
+	dt := angle(u, v)
+	if !sweep && dt > 0 {
+		dt -= Tau
+	} else if sweep && dt < 0 {
+		dt += Tau
+	}
+	n := arcSegments(math.Max(rx, ry), dt, maxError)
+	s := make(V2Set, n)
+	for i := range s {
+		sinT, cosT := math.Sincos(t0 + dt*float64(i+1)/float64(n))
+		x, y := rx*cosT, ry*sinT
+		s[i] = V2{cos*x - sin*y, sin*x + cos*y}.Add(c)
+	}
+	s[n-1] = p1
+	return s
+}
+
+// svgArgs is the number of arguments for each path command.
+var svgArgs = map[byte]int{
+	'M': 2, 'L': 2, 'H': 1, 'V': 1, 'C': 6, 'S': 4, 'Q': 4, 'T': 2, 'A': 7, 'Z': 0,
+}
+
+// svgPath returns the subpaths for path data.
+func svgPath(d string, maxError float64) ([]V2Set, error) {
+	sc := &svgScanner{s: d}
+	var paths []V2Set
+	var cur V2Set
+	var p, start, ctrl V2 // current point, subpath start, last control point
+	var cmd, last byte
+	lineTo := func(s ...V2) {
+		if cur == nil {
+			cur = V2Set{p}
+		}
+		cur = append(cur, s...)
+		p = cur[len(cur)-1]
+	}
+	closePath := func() {
+		if len(cur) > 2 {
+			paths = append(paths, cur)
+		}
+		cur = nil
+	}
+	for !sc.done() {
+		if c, ok := sc.command(); ok {
+			cmd = c
+		} else if cmd == 0 {
+			return nil, fmt.Errorf("bad path data at \"%s\"", sc.s[sc.i:])
+		}
+		rel := cmd >= 'a'
+		upper := cmd &^ 0x20
+		x := make([]float64, svgArgs[upper])
+		for i := range x {
+			var err error
+			if upper == 'A' && (i == 3 || i == 4) {
+				var f bool
+				f, err = sc.flag()
+				if f {
+					x[i] = 1
+				}
+			} else {
+				x[i], err = sc.number()
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		// the i-th point argument
+		pt := func(i int) V2 {
+			v := V2{x[2*i], x[2*i+1]}
+			if rel {
+				v = v.Add(p)
+			}
+			return v
+		}
+		switch upper {
+		case 'M':
+			closePath()
+			p = pt(0)
+			start = p
+			cur = V2Set{p}
+			// implicit commands after a move are lines
+			cmd = 'L' | (cmd & 0x20)
+		case 'L':
+			lineTo(pt(0))
+		case 'H':
+			v := V2{x[0], p.Y}
+			if rel {
+				v.X += p.X
+			}
+			lineTo(v)
+		case 'V':
+			v := V2{p.X, x[0]}
+			if rel {
+				v.Y += p.Y
+			}
+			lineTo(v)
+		case 'C':
+			c1, c2, e := pt(0), pt(1), pt(2)
+			lineTo(bezierPoints([]V2{p, c1, c2, e})...)
+			ctrl = c2
+		case 'S':
+			// the first control point is the reflection of the last one
+			c1 := p
+			if last == 'C' || last == 'S' {
+				c1 = p.MulScalar(2).Sub(ctrl)
+			}
+			c2, e := pt(0), pt(1)
+			lineTo(bezierPoints([]V2{p, c1, c2, e})...)
+			ctrl = c2
+		case 'Q':
+			c, e := pt(0), pt(1)
+			lineTo(bezierPoints([]V2{p, c, e})...)
+			ctrl = c
+		case 'T':
+			c := p
+			if last == 'Q' || last == 'T' {
+				c = p.MulScalar(2).Sub(ctrl)
+			}
+			e := pt(0)
+			lineTo(bezierPoints([]V2{p, c, e})...)
+			ctrl = c
+		case 'A':
+			e := V2{x[5], x[6]}
+			if rel {
+				e = e.Add(p)
+			}
+			s := svgArc(p, x[0], x[1], x[2], x[3] != 0, x[4] != 0, e, maxError)
+			if len(s) != 0 {
+				lineTo(s...)
+			}
+		case 'Z':
+			closePath()
+			p = start
+			// numbers after a close path are an error
+			cmd = 0
+		}
+		last = upper
+	}
+	closePath()
+	// remove the closing points
+	for i, s := range paths {
+		if n := len(s); s[n-1].Equals(s[0], tolerance) {
+			paths[i] = s[:n-1]
+		}
+	}
+	return paths, nil
+}
+
+// svgShape returns the subpaths of a shape element.
+func svgShape(name string, attrs map[string]string, maxError float64) ([]V2Set, error) {
+	num := func(name string) float64 {
+		x, _ := strconv.ParseFloat(strings.TrimSpace(attrs[name]), 64)
+		return x
+	}
+	switch name {
+	case "path":
+		return svgPath(attrs["d"], maxError)
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		if w <= 0 || h <= 0 {
+			return nil, nil
+		}
+		rx, ry := num("rx"), num("ry")
+		if _, ok := attrs["ry"]; !ok {
+			ry = rx
+		}
+		if _, ok := attrs["rx"]; !ok {
+			rx = ry
+		}
+		rx, ry = Clamp(rx, 0, w/2), Clamp(ry, 0, h/2)
+		if rx == 0 || ry == 0 {
+			return []V2Set{{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}}, nil
+		}
+		// rounded corners
+		return svgPath(fmt.Sprintf("M%g,%g H%g A%g,%g 0 0 1 %g,%g V%g A%g,%g 0 0 1 %g,%g H%g A%g,%g 0 0 1 %g,%g V%g A%g,%g 0 0 1 %g,%g Z",
+			x+rx, y, x+w-rx, rx, ry, x+w, y+ry, y+h-ry, rx, ry, x+w-rx, y+h, x+rx, rx, ry, x, y+h-ry, y+ry, rx, ry, x+rx, y), maxError)
+	case "circle", "ellipse":
+		rx, ry := num("rx"), num("ry")
+		if name == "circle" {
+			rx, ry = num("r"), num("r")
+		}
+		if rx <= 0 || ry <= 0 {
+			return nil, nil
+		}
+		s := ellipsePoints(V2{num("cx"), num("cy")}, V2{rx, 0}, ry/rx, 0, Tau, maxError)
+		return []V2Set{s[:len(s)-1]}, nil
+	case "polygon", "polyline":
+		x, err := svgNumbers(attrs["points"])
+		if err != nil {
+			return nil, err
+		}
+		var s V2Set
+		for i := 0; i+1 < len(x); i += 2 {
+			s = append(s, V2{x[i], x[i+1]})
+		}
+		return []V2Set{s}, nil
+	}
+	return nil, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// svgSkip are the elements that aren't drawn directly.
+var svgSkip = map[string]bool{
+	"defs":     true,
+	"clipPath": true,
+	"mask":     true,
+	"marker":   true,
+	"pattern":  true,
+	"symbol":   true,
+	"metadata": true,
+	"style":    true,
+	"text":     true,
+}
+
+// svgState is the state inherited by an element.
+type svgState struct {
+	transform M33
+	evenOdd   bool // even-odd (vs nonzero) fill rule
+	noFill    bool // fill is none
+}
+
+// svgRoot returns the transform for the svg root element.
+// User units are mapped to millimeters with y up.
+func svgRoot(attrs map[string]string) M33 {
+	width, wok := svgLength(attrs["width"])
+	height, hok := svgLength(attrs["height"])
+	vb, _ := svgNumbers(attrs["viewBox"])
+	scale := V2{svgUnits[""], svgUnits[""]}
+	var origin V2
+	if len(vb) == 4 && vb[2] > 0 && vb[3] > 0 {
+		origin = V2{vb[0], vb[1]}
+		if wok {
+			scale.X = width / vb[2]
+		}
+		if hok {
+			scale.Y = height / vb[3]
+		}
+		if wok && !hok {
+			scale.Y = scale.X
+		} else if hok && !wok {
+			scale.X = scale.Y
+		}
+		height, hok = vb[3]*scale.Y, true
+	}
+	if !hok {
+		height = 0
+	}
+	return Translate2d(V2{0, height}).Mul(Scale2d(V2{scale.X, -scale.Y})).Mul(Translate2d(origin.Neg()))
+}
+
+// DecodeSVG2D reads the filled shapes of an SVG document as an SDF2.
+func DecodeSVG2D(r io.Reader, k *SVGLoadParms) (SDF2, error) {
+	if k == nil {
+		k = &SVGLoadParms{}
+	}
+	dec := xml.NewDecoder(r)
+	var stack []svgState
+	var shapes []SDF2
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			attrs := make(map[string]string, len(t.Attr))
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			if display, _ := svgStyle(attrs, "display"); svgSkip[name] || display == "none" {
+				if err := dec.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			// inherit the parent state
+			state := svgState{transform: Identity2d()}
+			if len(stack) > 0 {
+				state = stack[len(stack)-1]
+			} else if name == "svg" {
+				state.transform = svgRoot(attrs)
+			}
+			if s, ok := attrs["transform"]; ok {
+				m, err := svgTransform(s)
+				if err != nil {
+					return nil, err
+				}
+				state.transform = state.transform.Mul(m)
+			}
+			if v, ok := svgStyle(attrs, "fill-rule"); ok && v != "inherit" {
+				state.evenOdd = v == "evenodd"
+			}
+			if v, ok := svgStyle(attrs, "fill"); ok && v != "inherit" {
+				state.noFill = v == "none"
+			}
+			stack = append(stack, state)
+			if state.noFill {
+				continue
+			}
+			// the arc flattening error in user units
+			maxError := k.maxError() / math.Sqrt(Abs(state.transform.Determinant()))
+			paths, err := svgShape(name, attrs, maxError)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			var closed []V2Set
+			for _, s := range paths {
+				s.MulVertices(state.transform)
+				if len(s) >= 3 && contourArea(s) != 0 {
+					closed = append(closed, s)
+				}
+			}
+			if len(closed) != 0 {
+				shapes = append(shapes, ContourPolygon2D(nestContours(closed, !state.evenOdd)))
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	s := Union2D(shapes...)
+	if s == nil {
+		return nil, errors.New("no filled shapes in SVG file")
+	}
+	return s, nil
+}
+
+// LoadSVG2D reads the filled shapes of an SVG file as an SDF2.
+func LoadSVG2D(path string, k *SVGLoadParms) (SDF2, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeSVG2D(f, k)
+}
+
+//-----------------------------------------------------------------------------
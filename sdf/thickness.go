@@ -0,0 +1,283 @@
+//-----------------------------------------------------------------------------
+/*
+
+Wall Thickness Analysis
+
+The wall thickness at a surface point is measured along a ray cast from the
+point into the object along the inward normal, up to where it leaves the
+object. For a wall with parallel faces this is twice the radius of the
+largest inscribed sphere, but unlike an inscribed sphere touching the point
+it isn't fooled by nearby sharp edges (which would read as thin).
+
+The thickness is measured at the vertices of a surface mesh, and connected
+vertices below the threshold are reported as thin regions. The two faces of
+a thin wall are one region: the measuring ray from the thinnest point of one
+face ends on the other. The results can be saved as a vertex colored PLY
+file, or drawn as a heat map of a slice.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sdf
+
+import (
+	"errors"
+	"image/color"
+	"math"
+	"sort"
+)
+
+//-----------------------------------------------------------------------------
+
+// ThicknessParms are the parameters for a wall thickness analysis.
+type ThicknessParms struct {
+	Threshold float64      // thinnest acceptable wall
+	Render    *RenderParms // surface mesh (nil == 200 cells on the longest axis)
+	Workers   int          // number of measuring workers (0 == runtime.NumCPU())
+}
+
+// ThicknessRegion is a region of the surface with thin walls.
+// It includes the faces on both sides of the walls.
+type ThicknessRegion struct {
+	Point    V3      // location of the thinnest wall
+	Min      float64 // minimum thickness
+	Box      Box3    // bounding box of the region
+	Vertices []int   // mesh vertices in the region
+}
+
+// ThicknessAnalysis is the result of a wall thickness analysis.
+type ThicknessAnalysis struct {
+	Mesh      *Mesh3             // surface mesh
+	Thickness []float64          // thickness at each mesh vertex (at most twice the threshold)
+	Regions   []*ThicknessRegion // regions thinner than the threshold, thinnest first
+	Threshold float64            // thinnest acceptable wall
+}
+
+//-----------------------------------------------------------------------------
+
+// thickness measures wall thicknesses for an SDF3.
+type thickness struct {
+	s   SDF3
+	max float64        // largest thickness measured
+	eps float64        // ray start distance below the surface
+	gp  *GradientParms // normal and closest point parameters
+	rp  *RayParms      // ray casting parameters
+}
+
+// newThickness returns a wall thickness measurer for an SDF3.
+func newThickness(s SDF3, threshold float64) *thickness {
+	size := s.BoundingBox().Size().MaxComponent()
+	eps := 1e-5 * size
+	return &thickness{
+		s:   s,
+		max: 2 * threshold,
+		eps: eps,
+		gp:  &GradientParms{Step: 0.1 * eps},
+		rp:  &RayParms{Epsilon: 0.01 * eps, MaxDistance: 2 * threshold},
+	}
+}
+
+// at returns the wall thickness at a point near the surface (at most twice
+// the threshold).
+func (t *thickness) at(p V3) float64 {
+	p = ClosestPoint3(t.s, p, t.gp)
+	n := Normal3(t.s, p, t.gp)
+	h, ok := Raycast3(t.s, p.Sub(n.MulScalar(t.eps)), n.Neg(), t.rp)
+	if !ok {
+		return t.max
+	}
+	return math.Min(h.T+t.eps, t.max)
+}
+
+// opposite returns the end of the measuring ray of length d from a point
+// near the surface.
+func (t *thickness) opposite(p V3, d float64) V3 {
+	p = ClosestPoint3(t.s, p, t.gp)
+	n := Normal3(t.s, p, t.gp)
+	return p.Sub(n.MulScalar(d))
+}
+
+//-----------------------------------------------------------------------------
+
+// thicknessRegions returns the regions of thin mesh vertices. Connected thin
+// vertices are in the same region, as are the faces on opposite sides of a
+// wall: the measuring ray from the thinnest vertex of one face ends within
+// the wall thickness of a vertex on the other.
+func thicknessRegions(m *Mesh3, thickness []float64, threshold float64, opposite func(p V3, d float64) V3) []*ThicknessRegion {
+	// union-find over the edges between thin vertices
+	parent := make([]int, len(m.V))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	thin := func(i int) bool { return thickness[i] < threshold }
+	for _, f := range m.F {
+		for i := 0; i < 3; i++ {
+			a, b := f[i], f[(i+1)%3]
+			if thin(a) && thin(b) {
+				parent[find(a)] = find(b)
+			}
+		}
+	}
+	// spatial hash of the thin vertices, the cells are the threshold in size
+	cell := func(p V3) V3i {
+		return p.DivScalar(threshold).Floor().ToV3i()
+	}
+	grid := make(map[V3i][]int)
+	for i, v := range m.V {
+		if thin(i) {
+			grid[cell(v)] = append(grid[cell(v)], i)
+		}
+	}
+	// the thinnest vertex of each connected set
+	thinnest := make(map[int]int)
+	for i := range m.V {
+		if !thin(i) {
+			continue
+		}
+		if j, ok := thinnest[find(i)]; !ok || thickness[i] < thickness[j] {
+			thinnest[find(i)] = i
+		}
+	}
+	sources := make([]int, 0, len(thinnest))
+	for _, i := range thinnest {
+		sources = append(sources, i)
+	}
+	sort.Ints(sources)
+	// merge the opposite faces of the walls: join each set to the set of the
+	// nearest thin vertex at the end of its measuring ray
+	for _, i := range sources {
+		q := opposite(m.V[i], thickness[i])
+		c := cell(q)
+		best, dmin := -1, thickness[i]
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for dz := -1; dz <= 1; dz++ {
+					for _, j := range grid[c.Add(V3i{dx, dy, dz})] {
+						if find(j) == find(i) {
+							continue
+						}
+						if d := m.V[j].Sub(q).Length(); d <= dmin {
+							best, dmin = j, d
+						}
+					}
+				}
+			}
+		}
+		if best >= 0 {
+			parent[find(i)] = find(best)
+		}
+	}
+	// collect the regions
+	index := make(map[int]*ThicknessRegion)
+	var regions []*ThicknessRegion
+	for i, v := range m.V {
+		if !thin(i) {
+			continue
+		}
+		r, ok := index[find(i)]
+		if !ok {
+			r = &ThicknessRegion{Point: v, Min: thickness[i], Box: Box3{v, v}}
+			index[find(i)] = r
+			regions = append(regions, r)
+		}
+		if thickness[i] < r.Min {
+			r.Point, r.Min = v, thickness[i]
+		}
+		r.Box = r.Box.Extend(Box3{v, v})
+		r.Vertices = append(r.Vertices, i)
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Min < regions[j].Min })
+	return regions
+}
+
+// AnalyzeThickness finds the regions of an SDF3 with walls thinner than a threshold.
+// The thickness is measured at the vertices of a surface mesh.
+func AnalyzeThickness(s SDF3, k *ThicknessParms) (*ThicknessAnalysis, error) {
+	if k == nil || k.Threshold <= 0 {
+		return nil, errors.New("thickness threshold must be > 0")
+	}
+	rp := k.Render
+	if rp == nil {
+		rp = &RenderParms{MeshCells: 200}
+	}
+	m, _, err := GenerateMesh3(s, rp)
+	if err != nil {
+		return nil, err
+	}
+	t := newThickness(s, k.Threshold)
+	a := &ThicknessAnalysis{
+		Mesh:      m,
+		Thickness: make([]float64, len(m.V)),
+		Threshold: k.Threshold,
+	}
+	parallelFor(k.Workers, len(m.V), func(i int) {
+		a.Thickness[i] = t.at(m.V[i])
+	})
+	a.Regions = thicknessRegions(m, a.Thickness, k.Threshold, t.opposite)
+	return a, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// thicknessColor returns the heat map color for a thickness:
+// red (zero), yellow (threshold), green (twice the threshold).
+func thicknessColor(t, threshold float64) color.NRGBA {
+	red := color.NRGBA{0xd7, 0x19, 0x1c, 0xff}
+	yellow := color.NRGBA{0xff, 0xdf, 0x40, 0xff}
+	green := color.NRGBA{0x1a, 0x96, 0x41, 0xff}
+	f := Clamp(t/threshold, 0, 2)
+	if f < 1 {
+		return pngLerp(red, yellow, f)
+	}
+	return pngLerp(yellow, green, f-1)
+}
+
+// Colors returns the heat map colors for the mesh vertices.
+func (a *ThicknessAnalysis) Colors() []color.Color {
+	c := make([]color.Color, len(a.Thickness))
+	for i, t := range a.Thickness {
+		c[i] = thicknessColor(t, a.Threshold)
+	}
+	return c
+}
+
+// SavePLY writes the surface mesh with vertex heat map colors and the
+// thickness as a scalar to a PLY file.
+func (a *ThicknessAnalysis) SavePLY(path string) error {
+	return SavePLY(path, a.Mesh, &PLYParms{
+		Colors:     a.Colors(),
+		Scalar:     a.Thickness,
+		ScalarName: "thickness",
+	})
+}
+
+// RenderThickness draws a heat map of the wall thickness in a z slice of an
+// SDF3. Each point inside the object is colored with the thickness at the
+// closest surface point.
+func (d *PNG) RenderThickness(s SDF3, z float64, k *ThicknessParms) error {
+	if k == nil || k.Threshold <= 0 {
+		return errors.New("thickness threshold must be > 0")
+	}
+	t := newThickness(s, k.Threshold)
+	parallelFor(k.Workers, d.pixels[1], func(y int) {
+		for x := 0; x < d.pixels[0]; x++ {
+			p := d.m.ToV2(V2i{x, y})
+			q := V3{p.X, p.Y, z}
+			c := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+			if s.Evaluate(q) <= 0 {
+				c = thicknessColor(t.at(q), k.Threshold)
+			}
+			d.img.Set(x, y, c)
+		}
+	})
+	return nil
+}
+
+//-----------------------------------------------------------------------------
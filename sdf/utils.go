@@ -6,7 +6,11 @@ package sdf
 
 import (
 	"fmt"
+	"io"
 	"math"
+	"os"
+	"runtime"
+	"sync"
 )
 
 //-----------------------------------------------------------------------------
@@ -311,3 +315,54 @@ func MapCombinations(n, k int, f func([]int)) {
 }
 
 //-----------------------------------------------------------------------------
+
+// saveFile creates a file and writes to it with the write function.
+func saveFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// printWriteError prints the error from a stream writer.
+func printWriteError(wg *sync.WaitGroup, errc <-chan error) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := <-errc; err != nil {
+			fmt.Printf("%s\n", err)
+		}
+	}()
+}
+
+//-----------------------------------------------------------------------------
+
+// parallelFor calls fn for 0 <= i < n on a number of workers (0 == runtime.NumCPU()).
+func parallelFor(workers, n int, fn func(i int)) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+//-----------------------------------------------------------------------------
@@ -198,6 +198,16 @@ func (a V2) Ceil() V2 {
 	return V2{math.Ceil(a.X), math.Ceil(a.Y)}
 }
 
+// Floor takes the floor value of each vector component.
+func (a V3) Floor() V3 {
+	return V3{math.Floor(a.X), math.Floor(a.Y), math.Floor(a.Z)}
+}
+
+// Floor takes the floor value of each vector component.
+func (a V2) Floor() V2 {
+	return V2{math.Floor(a.X), math.Floor(a.Y)}
+}
+
 //-----------------------------------------------------------------------------
 
 // Clamp clamps a vector between 2 other vectors.
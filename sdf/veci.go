@@ -73,3 +73,27 @@ func (a V3i) Add(b V3i) V3i {
 }
 
 //-----------------------------------------------------------------------------
+
+// Sub subtracts two vectors. Return v = a - b.
+func (a V2i) Sub(b V2i) V2i {
+	return V2i{a[0] - b[0], a[1] - b[1]}
+}
+
+// Sub subtracts two vectors. Return v = a - b.
+func (a V3i) Sub(b V3i) V3i {
+	return V3i{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+//-----------------------------------------------------------------------------
+
+// MulScalar multiplies each vector component by a scalar.
+func (a V2i) MulScalar(k int) V2i {
+	return V2i{a[0] * k, a[1] * k}
+}
+
+// MulScalar multiplies each vector component by a scalar.
+func (a V3i) MulScalar(k int) V3i {
+	return V3i{a[0] * k, a[1] * k, a[2] * k}
+}
+
+//-----------------------------------------------------------------------------